@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// captionFetchTimeout bounds how long fetching a remote caption file may take
+const captionFetchTimeout = 30 * time.Second
+
+// ErrInvalidCaptionURL is returned when a caption URL isn't an absolute http(s) URL
+var ErrInvalidCaptionURL = errors.New("invalid caption URL")
+
+var captionHTTPClient = &http.Client{Timeout: captionFetchTimeout}
+
+// youtubeTimedTextURL returns the URL of a video's caption track in the given language, as VTT
+func youtubeTimedTextURL(videoID, language string) string {
+	query := url.Values{
+		"v":    {videoID},
+		"lang": {language},
+		"fmt":  {"vtt"},
+	}
+	return "https://www.youtube.com/api/timedtext?" + query.Encode()
+}
+
+// CaptionFile is a subtitle file downloaded from a URL
+type CaptionFile struct {
+	Content  string
+	Type     string
+	Language string
+}
+
+// fetchCaptionFile downloads a caption file of at most maxSize bytes, inferring its
+// type and language from the URL or response headers where possible
+func fetchCaptionFile(ctx context.Context, rawURL string, maxSize int) (*CaptionFile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w %q", ErrInvalidCaptionURL, rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := captionHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch captions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch captions: unexpected status %s", resp.Status)
+	}
+
+	// Read one byte past the limit to detect oversized files
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read captions: %w", err)
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("caption file exceeds the maximum size of %d bytes", maxSize)
+	}
+
+	file := &CaptionFile{Content: string(body)}
+	file.Type, file.Language = subtitleInfoFromFilename(path.Base(u.Path))
+
+	// YouTube's timedtext endpoint describes the track in query parameters
+	if format := u.Query().Get("fmt"); format != "" && file.Type == "" {
+		file.Type = format
+	}
+	if lang := u.Query().Get("lang"); lang != "" && file.Language == "" {
+		file.Language = lang
+	}
+
+	if file.Type == "" {
+		file.Type = captionTypeFromContentType(resp.Header.Get("Content-Type"))
+	}
+
+	return file, nil
+}
+
+// captionTypeFromContentType maps a response Content-Type to a subtitle type
+func captionTypeFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(mediaType) {
+	case "text/vtt":
+		return "vtt"
+	case "application/x-subrip", "application/srt", "text/srt":
+		return "srt"
+	case "application/ttml+xml":
+		return "ttml"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// gzipString compresses s with gzip
+func gzipString(s string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipString decompresses data written by gzipString
+func gunzipString(data string) (string, error) {
+	zr, err := gzip.NewReader(strings.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// blobLiteral writes data as an SQL blob literal. Queries are interpolated rather than
+// prepared, and binary data can't be safely quoted as a string
+func blobLiteral(data []byte) exp.LiteralExpression {
+	return goqu.L("X'" + hex.EncodeToString(data) + "'")
+}
+
+// subtitleSize counts a subtitle's lines, ignoring trailing blank lines, and characters
+func subtitleSize(content string) (lines, chars int) {
+	trimmed := strings.TrimRight(content, "\n")
+	if trimmed != "" {
+		lines = strings.Count(trimmed, "\n") + 1
+	}
+	return lines, utf8.RuneCountInString(content)
+}
+
+// subtitleContentRecord holds the columns written along with a subtitle's content,
+// compressing the content and original upload if compress is set
+func subtitleContentRecord(content, originalContent string, compress bool) (goqu.Record, error) {
+	lines, chars := subtitleSize(content)
+	cues, span := cueSummary(content)
+	record := goqu.Record{
+		"content":          content,
+		"original_content": originalContent,
+		"compressed":       false,
+		"line_count":       lines,
+		"char_count":       chars,
+		"cue_count":        cues,
+		"duration_ms":      span.Milliseconds(),
+	}
+	if !compress {
+		return record, nil
+	}
+
+	compressed, err := gzipString(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress subtitle: %w", err)
+	}
+	compressedOriginal, err := gzipString(originalContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress original subtitle: %w", err)
+	}
+
+	record["content"] = blobLiteral(compressed)
+	record["original_content"] = blobLiteral(compressedOriginal)
+	record["compressed"] = true
+	return record, nil
+}
+
+// decompressSubtitle restores the content of a subtitle stored compressed. Fields that
+// weren't selected are left empty
+func decompressSubtitle(subtitle *Subtitle) error {
+	if !subtitle.Compressed {
+		return nil
+	}
+
+	var err error
+	if subtitle.Content != "" {
+		if subtitle.Content, err = gunzipString(subtitle.Content); err != nil {
+			return fmt.Errorf("failed to decompress subtitle %d: %w", subtitle.ID, err)
+		}
+	}
+	if subtitle.OriginalContent != "" {
+		if subtitle.OriginalContent, err = gunzipString(subtitle.OriginalContent); err != nil {
+			return fmt.Errorf("failed to decompress original of subtitle %d: %w", subtitle.ID, err)
+		}
+	}
+	subtitle.Compressed = false
+	return nil
+}
@@ -3,114 +3,292 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
-	_ "modernc.org/sqlite"
+	"github.com/doug-martin/goqu/v9/exec"
+	"github.com/doug-martin/goqu/v9/exp"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// ErrSubtitleExists is returned when a subtitle with the same video, language and type already exists
+var ErrSubtitleExists = errors.New("subtitle already exists")
+
+// ErrVideoExists is returned when a video with the same URL already exists, including soft-deleted ones
+var ErrVideoExists = errors.New("video already exists")
+
+// ErrTooManySubtitles is returned when adding a subtitle would take a video past its subtitle limit
+var ErrTooManySubtitles = errors.New("video has the maximum number of subtitles")
+
+// dbHandle is the subset of goqu.Database and goqu.TxDatabase used by the repository,
+// so the same queries run both inside and outside a transaction
+type dbHandle interface {
+	From(from ...interface{}) *goqu.SelectDataset
+	Insert(table interface{}) *goqu.InsertDataset
+	Update(table interface{}) *goqu.UpdateDataset
+	Delete(table interface{}) *goqu.DeleteDataset
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 // Repository handles all database operations
 type Repository struct {
-	db *goqu.Database
+	db dbHandle
+	// conn is the underlying database, nil for repositories bound to a transaction
+	conn *goqu.Database
+	// busyAttempts is how many times a write is tried while the database is busy
+	busyAttempts int
+	// compressSubtitles gzips subtitle content on write, compressed rows are read either way
+	compressSubtitles bool
+	// maxSubtitlesPerVideo caps how many subtitles a video can have, zero means no limit
+	maxSubtitlesPerVideo int
 }
 
 // VideoWithSubs represents a video with its subtitles
 type VideoWithSubs struct {
 	Video
-	Subtitles []Subtitle `json:"subtitles"`
+	SubtitleCount int        `json:"subtitle_count"`
+	Subtitles     []Subtitle `json:"subtitles"`
+}
+
+// SubtitleFilter narrows a subtitle listing, empty fields match everything
+type SubtitleFilter struct {
+	Language string
+	Type     string
+}
+
+// VideoFilter narrows a video listing, zero fields match everything
+type VideoFilter struct {
+	// Query matches videos whose title or URL contains it, case-insensitively
+	Query string
+	// HasSubtitles matches videos with at least one subtitle if true, or none if false
+	HasSubtitles *bool
+}
+
+// LanguageCount is the number of subtitles available in a language
+type LanguageCount struct {
+	Language string `json:"language" db:"language"`
+	Count    int    `json:"count" db:"count"`
+}
+
+// LibraryStats summarizes the active videos and their subtitles
+type LibraryStats struct {
+	Videos       int             `json:"videos"`
+	Subtitles    int             `json:"subtitles"`
+	ContentBytes int64           `json:"content_bytes"`
+	Languages    []LanguageCount `json:"languages"`
+	Database     *DatabaseStats  `json:"database,omitempty"`
+}
+
+// DatabaseStats describes the database's storage, to monitor its growth
+type DatabaseStats struct {
+	SizeBytes    int64 `json:"size_bytes"`
+	WALSizeBytes int64 `json:"wal_size_bytes"`
+	// FreeBytes is the space taken by unused pages, reclaimable with VACUUM
+	FreeBytes int64 `json:"free_bytes"`
+	// Rows counts every row per table, including soft-deleted videos
+	Rows map[string]int64 `json:"rows"`
+}
+
+// DatabaseConfig holds the tunable SQLite pragmas and connection pool size
+type DatabaseConfig struct {
+	CacheSizeKB  int           // page cache size in KiB, per connection
+	MmapSize     int           // memory-mapped I/O size in bytes
+	BusyTimeout  time.Duration // how long to wait for a locked database
+	MaxOpenConns int           // connection pool size
+	BusyAttempts int           // tries for a write that fails with SQLITE_BUSY, with exponential backoff
+	// CompressSubtitles stores new and updated subtitle content gzipped
+	CompressSubtitles bool
+	// MaxSubtitlesPerVideo caps how many subtitles a video can have, zero means no limit
+	MaxSubtitlesPerVideo int
 }
 
+// DefaultDatabaseConfig is used for any setting that isn't overridden
+var DefaultDatabaseConfig = DatabaseConfig{
+	CacheSizeKB:  64000,             // 64MB cache
+	MmapSize:     256 * 1024 * 1024, // 256MB memory-mapped I/O
+	BusyTimeout:  5 * time.Second,
+	MaxOpenConns: 4, // WAL allows concurrent readers, but only one writer
+	BusyAttempts: 3,
+
+	MaxSubtitlesPerVideo: 50,
+}
+
+// busyRetryBaseDelay is the wait before the first retry of a busy write, doubled for each retry after it
+const busyRetryBaseDelay = 50 * time.Millisecond
+
+// videoThumbnailURL computes a video's YouTube thumbnail URL in SQL, matching youtubeThumbnailURL
+var videoThumbnailURL = goqu.L("CASE WHEN video_id = '' THEN '' ELSE ? || video_id || ? END",
+	youtubeThumbnailPrefix, youtubeThumbnailSuffix).As("thumbnail_url")
+
 // NewRepository creates a new repository instance
-func NewRepository(dbPath string) (*Repository, error) {
-	sqlDB, err := sql.Open("sqlite", dbPath)
+func NewRepository(dbPath string, cfg DatabaseConfig) (*Repository, error) {
+	// Connection-scoped pragmas go in the DSN so the driver applies them to
+	// every connection in the pool, not just the first one
+	connPragmas := []string{
+		fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeout.Milliseconds()),
+		"foreign_keys(1)",     // Enforce foreign key constraints
+		"synchronous(NORMAL)", // Balanced durability/performance
+		fmt.Sprintf("cache_size(-%d)", cfg.CacheSizeKB),
+		"temp_store(MEMORY)", // Store temp tables in memory
+		fmt.Sprintf("mmap_size(%d)", cfg.MmapSize),
+		"journal_size_limit(67108864)", // 64MB journal size limit
+		"wal_autocheckpoint(1000)",     // Checkpoint every 1000 pages
+	}
+
+	sqlDB, err := sql.Open("sqlite", sqliteDSN(dbPath, connPragmas))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	// Set good SQLite pragmas for performance and reliability
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL",            // Write-Ahead Logging for better concurrency
-		"PRAGMA synchronous=NORMAL",          // Balanced durability/performance
-		"PRAGMA cache_size=-64000",           // 64MB cache
-		"PRAGMA busy_timeout=5000",           // 5 second timeout for locked database
-		"PRAGMA foreign_keys=ON",             // Enforce foreign key constraints
-		"PRAGMA temp_store=MEMORY",           // Store temp tables in memory
-		"PRAGMA mmap_size=268435456",         // 256MB memory-mapped I/O
-		"PRAGMA page_size=4096",              // 4KB page size (must be set before DB creation)
-		"PRAGMA auto_vacuum=INCREMENTAL",     // Incremental auto-vacuum
-		"PRAGMA journal_size_limit=67108864", // 64MB journal size limit
-		"PRAGMA wal_autocheckpoint=1000",     // Checkpoint every 1000 pages
+	// Keep idle connections around, since each one is set up with the pragmas above
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxOpenConns)
+
+	// Database-wide tuning that's a no-op on an existing database. This has to come before
+	// switching to WAL, which creates the database file
+	optionalPragmas := []string{
+		"PRAGMA page_size=4096",          // 4KB page size (must be set before DB creation)
+		"PRAGMA auto_vacuum=INCREMENTAL", // Incremental auto-vacuum
 	}
 
-	for _, pragma := range pragmas {
+	for _, pragma := range optionalPragmas {
 		if _, err := sqlDB.Exec(pragma); err != nil {
-			return nil, fmt.Errorf("failed to set pragma %s: %w", pragma, err)
+			slog.Warn("Failed to set optional pragma", "pragma", pragma, "error", err)
 		}
 	}
 
+	// Write-Ahead Logging for better concurrency, which persists in the database file
+	if _, err := sqlDB.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, fmt.Errorf("failed to set pragma journal_mode: %w", err)
+	}
+
 	db := goqu.New("sqlite3", sqlDB)
 
-	repo := &Repository{db: db}
-	if err := repo.initDB(); err != nil {
+	repo := &Repository{
+		db:                   db,
+		conn:                 db,
+		busyAttempts:         cfg.BusyAttempts,
+		compressSubtitles:    cfg.CompressSubtitles,
+		maxSubtitlesPerVideo: cfg.MaxSubtitlesPerVideo,
+	}
+	if err := repo.runMigrations(); err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
 	return repo, nil
 }
 
+// sqliteDSN appends pragmas to a database path as _pragma query parameters. Transactions
+// take the write lock when they begin, so a check and the write that depends on it can't
+// interleave with another transaction's
+func sqliteDSN(dbPath string, pragmas []string) string {
+	query := url.Values{"_pragma": pragmas, "_txlock": {"immediate"}}.Encode()
+	if strings.Contains(dbPath, "?") {
+		return dbPath + "&" + query
+	}
+	return dbPath + "?" + query
+}
+
+// Ping verifies the database is reachable by running a trivial query
+func (r *Repository) Ping(ctx context.Context) error {
+	var one int
+	if err := r.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (r *Repository) Close() error {
-	if sqlDB, ok := r.db.Db.(*sql.DB); ok {
+	if r.conn == nil {
+		return nil
+	}
+	if sqlDB, ok := r.conn.Db.(*sql.DB); ok {
+		// Fold the WAL back into the database so the -wal file doesn't carry over between runs
+		if _, err := sqlDB.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			slog.Warn("Failed to checkpoint WAL", "error", err)
+		}
 		return sqlDB.Close()
 	}
 	return nil
 }
 
-// initDB creates the database tables if they don't exist
-func (r *Repository) initDB() error {
-	sqlDB, ok := r.db.Db.(*sql.DB)
-	if !ok {
-		return fmt.Errorf("failed to get sql.DB instance")
+// WithTx runs fn with a repository bound to a single transaction. The transaction is
+// committed if fn returns nil and rolled back otherwise. Nested calls reuse the
+// outer transaction. If the database is busy, the whole transaction is retried like
+// execWithRetry retries a write, so fn may run more than once
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) error {
+	if r.conn == nil {
+		return fn(r)
 	}
 
-	// Create videos table
-	_, err := sqlDB.Exec(`
-		CREATE TABLE IF NOT EXISTS videos (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			original_url TEXT NOT NULL UNIQUE,
-			title TEXT NOT NULL
-		)
-	`)
+	return r.retryBusy(ctx, "transaction", func() error {
+		return r.runTx(ctx, fn)
+	})
+}
+
+// runTx runs fn in a single attempt at a transaction
+func (r *Repository) runTx(ctx context.Context, fn func(tx *Repository) error) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create videos table: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	// Create subtitles table
-	_, err = sqlDB.Exec(`
-		CREATE TABLE IF NOT EXISTS subtitles (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			video_id INTEGER NOT NULL,
-			language TEXT NOT NULL,
-			type TEXT NOT NULL,
-			content TEXT NOT NULL,
-			FOREIGN KEY (video_id) REFERENCES videos(id) ON DELETE CASCADE
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create subtitles table: %w", err)
+	txRepo := &Repository{db: tx, compressSubtitles: r.compressSubtitles, maxSubtitlesPerVideo: r.maxSubtitlesPerVideo}
+	if err := fn(txRepo); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			slog.Error("Failed to roll back transaction", "error", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// GetVideoByURL finds a video by a URL pattern containing the video ID
+// GetVideoByURL finds a video by its exact YouTube video ID
 func (r *Repository) GetVideoByURL(ctx context.Context, videoID string) (*Video, error) {
+	defer observeDBOperation("get_video_by_url", time.Now())
+
+	var video Video
+	found, err := r.db.From("videos").
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", videoThumbnailURL).
+		Where(goqu.C("video_id").Eq(videoID), goqu.C("deleted_at").IsNull()).
+		ScanStructContext(ctx, &video)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query video: %w", err)
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	return &video, nil
+}
+
+// GetVideoByID retrieves a video by its ID
+func (r *Repository) GetVideoByID(ctx context.Context, id int) (*Video, error) {
+	defer observeDBOperation("get_video_by_id", time.Now())
+
 	var video Video
 	found, err := r.db.From("videos").
-		Select("id", "original_url", "title").
-		Where(goqu.L("original_url LIKE ?", "%"+videoID+"%")).
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", videoThumbnailURL).
+		Where(goqu.C("id").Eq(id), goqu.C("deleted_at").IsNull()).
 		ScanStructContext(ctx, &video)
 
 	if err != nil {
@@ -123,12 +301,61 @@ func (r *Repository) GetVideoByURL(ctx context.Context, videoID string) (*Video,
 	return &video, nil
 }
 
-// GetSubtitlesByVideoID retrieves all subtitles for a given video ID
-func (r *Repository) GetSubtitlesByVideoID(ctx context.Context, videoID int) ([]Subtitle, error) {
+// GetSubtitleByID retrieves a single subtitle by its ID, including the original upload
+func (r *Repository) GetSubtitleByID(ctx context.Context, id int) (*Subtitle, error) {
+	defer observeDBOperation("get_subtitle_by_id", time.Now())
+
+	var subtitle Subtitle
+	found, err := r.db.From("subtitles").
+		Select("id", "video_id", "language", "type", "content", "original_type", "original_content", "compressed", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
+		Where(goqu.C("id").Eq(id), goqu.C("video_id").In(activeVideoIDs(r.db))).
+		ScanStructContext(ctx, &subtitle)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subtitle: %w", err)
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	if err := decompressSubtitle(&subtitle); err != nil {
+		return nil, err
+	}
+
+	return &subtitle, nil
+}
+
+// GetSubtitleByLanguage retrieves a video's subtitle in the given language
+func (r *Repository) GetSubtitleByLanguage(ctx context.Context, videoID int, language string) (*Subtitle, error) {
+	defer observeDBOperation("get_subtitle_by_language", time.Now())
+
+	var subtitle Subtitle
+	found, err := r.db.From("subtitles").
+		Select("id", "video_id", "language", "type", "content", "original_type", "compressed", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
+		Where(goqu.C("video_id").Eq(videoID), goqu.C("language").Eq(language)).
+		ScanStructContext(ctx, &subtitle)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subtitle: %w", err)
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	if err := decompressSubtitle(&subtitle); err != nil {
+		return nil, err
+	}
+
+	return &subtitle, nil
+}
+
+// ListSubtitleMetadata retrieves a video's subtitles without their content
+func (r *Repository) ListSubtitleMetadata(ctx context.Context, videoID int) ([]Subtitle, error) {
+	defer observeDBOperation("list_subtitle_metadata", time.Now())
+
 	var subtitles []Subtitle
 	err := r.db.From("subtitles").
-		Select("id", "video_id", "language", "type", "content").
+		Select("id", "video_id", "language", "type", "original_type", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
 		Where(goqu.C("video_id").Eq(videoID)).
+		Order(goqu.C("language").Asc()).
 		ScanStructsContext(ctx, &subtitles)
 
 	if err != nil {
@@ -142,61 +369,550 @@ func (r *Repository) GetSubtitlesByVideoID(ctx context.Context, videoID int) ([]
 	return subtitles, nil
 }
 
+// GetSubtitlesByVideoID retrieves all subtitles for a given video ID. With a non-nil
+// languageOrder, subtitles in those languages come first in that order, followed by the
+// rest sorted by language. A nil languageOrder keeps them in insertion order
+func (r *Repository) GetSubtitlesByVideoID(ctx context.Context, videoID int, languageOrder []string) ([]Subtitle, error) {
+	defer observeDBOperation("get_subtitles_by_video_id", time.Now())
+
+	ds := r.db.From("subtitles").
+		Select("id", "video_id", "language", "type", "content", "original_type", "compressed", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
+		Where(goqu.C("video_id").Eq(videoID))
+	switch {
+	case languageOrder == nil:
+		ds = ds.Order(goqu.C("id").Asc())
+	case len(languageOrder) == 0:
+		ds = ds.Order(goqu.C("language").Asc(), goqu.C("id").Asc())
+	default:
+		ds = ds.Order(languageRank(languageOrder).Asc(), goqu.C("language").Asc(), goqu.C("id").Asc())
+	}
+
+	var subtitles []Subtitle
+	err := ds.ScanStructsContext(ctx, &subtitles)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subtitles: %w", err)
+	}
+	for i := range subtitles {
+		if err := decompressSubtitle(&subtitles[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if subtitles == nil {
+		subtitles = []Subtitle{}
+	}
+
+	return subtitles, nil
+}
+
+// ListSubtitlesPaged retrieves a page of a video's subtitles matching filter, sorted by
+// language, along with the total number of matches
+func (r *Repository) ListSubtitlesPaged(ctx context.Context, videoID int, filter SubtitleFilter, limit, offset int) ([]Subtitle, int, error) {
+	defer observeDBOperation("list_subtitles_paged", time.Now())
+
+	ds := r.db.From("subtitles").Where(goqu.C("video_id").Eq(videoID))
+	if filter.Language != "" {
+		ds = ds.Where(goqu.C("language").Eq(filter.Language))
+	}
+	if filter.Type != "" {
+		ds = ds.Where(goqu.C("type").Eq(filter.Type))
+	}
+
+	total, err := ds.CountContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count subtitles: %w", err)
+	}
+
+	var subtitles []Subtitle
+	err = ds.
+		Select("id", "video_id", "language", "type", "content", "original_type", "compressed", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
+		Order(goqu.C("language").Asc(), goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Offset(uint(offset)).
+		ScanStructsContext(ctx, &subtitles)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query subtitles: %w", err)
+	}
+	for i := range subtitles {
+		if err := decompressSubtitle(&subtitles[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if subtitles == nil {
+		subtitles = []Subtitle{}
+	}
+
+	return subtitles, int(total), nil
+}
+
 // ListAllVideos retrieves all videos with their subtitles
 func (r *Repository) ListAllVideos(ctx context.Context) ([]VideoWithSubs, error) {
-	// First get all videos
+	defer observeDBOperation("list_all_videos", time.Now())
+
+	// First get all videos, newest first
+	var videos []Video
+	err := r.db.From("videos").
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", videoThumbnailURL).
+		Where(goqu.C("deleted_at").IsNull()).
+		Order(goqu.C("created_at").Desc(), goqu.C("id").Desc()).
+		ScanStructsContext(ctx, &videos)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query videos: %w", err)
+	}
+
+	return r.withSubtitles(ctx, videos)
+}
+
+// ListVideosAfterID retrieves up to limit videos with an ID greater than afterID, in ID order,
+// so callers can walk the whole library in batches
+func (r *Repository) ListVideosAfterID(ctx context.Context, afterID, limit int) ([]Video, error) {
+	defer observeDBOperation("list_videos_after_id", time.Now())
+
 	var videos []Video
 	err := r.db.From("videos").
-		Select("id", "original_url", "title").
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", videoThumbnailURL).
+		Where(goqu.C("deleted_at").IsNull(), goqu.C("id").Gt(afterID)).
+		Order(goqu.C("id").Asc()).
+		Limit(uint(limit)).
 		ScanStructsContext(ctx, &videos)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query videos: %w", err)
 	}
 
-	if videos == nil {
+	return videos, nil
+}
+
+// ListVideosPaged retrieves a page of videos matching filter with their subtitles, along
+// with the total number of matches
+func (r *Repository) ListVideosPaged(ctx context.Context, filter VideoFilter, limit, offset int) ([]VideoWithSubs, int, error) {
+	defer observeDBOperation("list_videos_paged", time.Now())
+
+	ds := r.db.From("videos").Where(goqu.C("deleted_at").IsNull())
+	if filter.Query != "" {
+		// Escape LIKE wildcards so they match literally
+		pattern := "%" + likeEscaper.Replace(filter.Query) + "%"
+		ds = ds.Where(goqu.Or(
+			goqu.L(`title LIKE ? ESCAPE '\'`, pattern),
+			goqu.L(`original_url LIKE ? ESCAPE '\'`, pattern),
+		))
+	}
+	if filter.HasSubtitles != nil {
+		withSubtitles := r.db.From("subtitles").Select("video_id")
+		if *filter.HasSubtitles {
+			ds = ds.Where(goqu.C("id").In(withSubtitles))
+		} else {
+			ds = ds.Where(goqu.C("id").NotIn(withSubtitles))
+		}
+	}
+
+	return r.videosPaged(ctx, ds, limit, offset)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// videosPaged returns a page of the videos in ds, newest first, along with their total count
+func (r *Repository) videosPaged(ctx context.Context, ds *goqu.SelectDataset, limit, offset int) ([]VideoWithSubs, int, error) {
+	total, err := ds.CountContext(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count videos: %w", err)
+	}
+
+	var videos []Video
+	err = ds.
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", videoThumbnailURL).
+		Order(goqu.C("created_at").Desc(), goqu.C("id").Desc()).
+		Limit(uint(limit)).
+		Offset(uint(offset)).
+		ScanStructsContext(ctx, &videos)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query videos: %w", err)
+	}
+
+	withSubs, err := r.withSubtitles(ctx, videos)
+	if err != nil {
+		return nil, 0, err
+	}
+	return withSubs, int(total), nil
+}
+
+// withSubtitles attaches subtitles (without content) to the given videos.
+// Failures are returned rather than reported as videos without subtitles
+func (r *Repository) withSubtitles(ctx context.Context, videos []Video) ([]VideoWithSubs, error) {
+	if len(videos) == 0 {
 		return []VideoWithSubs{}, nil
 	}
 
-	// For each video, get its subtitles
+	videoIDs := make([]int, len(videos))
+	for i, video := range videos {
+		videoIDs[i] = video.ID
+	}
+
+	// Fetch all subtitles in a single query, without content
+	var subtitles []Subtitle
+	err := r.db.From("subtitles").
+		Select("id", "video_id", "language", "type", "original_type", "created_at", "updated_at", "line_count", "char_count", "cue_count", "duration_ms").
+		Where(goqu.C("video_id").In(videoIDs)).
+		ScanStructsContext(ctx, &subtitles)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subtitles for videos: %w", err)
+	}
+
+	// Group subtitles by video ID
+	subsByVideo := make(map[int][]Subtitle, len(videos))
+	for _, subtitle := range subtitles {
+		subsByVideo[subtitle.VideoID] = append(subsByVideo[subtitle.VideoID], subtitle)
+	}
+
 	result := make([]VideoWithSubs, 0, len(videos))
 	for _, video := range videos {
-		var subtitles []Subtitle
-		err := r.db.From("subtitles").
-			Select("id", "video_id", "language", "type").
-			Where(goqu.C("video_id").Eq(video.ID)).
-			ScanStructsContext(ctx, &subtitles)
+		subs := subsByVideo[video.ID]
+		if subs == nil {
+			subs = []Subtitle{}
+		}
+
+		result = append(result, VideoWithSubs{
+			Video:         video,
+			SubtitleCount: len(subs),
+			Subtitles:     subs,
+		})
+	}
+
+	return result, nil
+}
+
+// CountSubtitlesByVideo returns the number of subtitles for each of the given videos
+func (r *Repository) CountSubtitlesByVideo(ctx context.Context, videoIDs []int) (map[int]int, error) {
+	var rows []struct {
+		VideoID int `db:"video_id"`
+		Count   int `db:"count"`
+	}
+	err := r.db.From("subtitles").
+		Select("video_id", goqu.COUNT("*").As("count")).
+		Where(goqu.C("video_id").In(videoIDs)).
+		GroupBy("video_id").
+		ScanStructsContext(ctx, &rows)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to count subtitles: %w", err)
+	}
+
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.VideoID] = row.Count
+	}
+
+	return counts, nil
+}
+
+// CountSubtitlesByLanguage returns the distinct subtitle languages of non-deleted videos with their counts
+func (r *Repository) CountSubtitlesByLanguage(ctx context.Context) ([]LanguageCount, error) {
+	defer observeDBOperation("count_subtitles_by_language", time.Now())
+
+	var counts []LanguageCount
+	err := r.db.From("subtitles").
+		Select("language", goqu.COUNT("*").As("count")).
+		Where(goqu.C("video_id").In(activeVideoIDs(r.db))).
+		GroupBy("language").
+		Order(goqu.C("language").Asc()).
+		ScanStructsContext(ctx, &counts)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to count subtitles by language: %w", err)
+	}
+
+	if counts == nil {
+		counts = []LanguageCount{}
+	}
+
+	return counts, nil
+}
+
+// Stats computes library totals with aggregate queries, ignoring soft-deleted videos
+func (r *Repository) Stats(ctx context.Context) (*LibraryStats, error) {
+	defer observeDBOperation("stats", time.Now())
+
+	var stats LibraryStats
+	videos, err := r.db.From("videos").
+		Where(goqu.C("deleted_at").IsNull()).
+		CountContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count videos: %w", err)
+	}
+	stats.Videos = int(videos)
+
+	// Content size counts both the SRT and the original upload, in bytes rather than characters
+	var totals struct {
+		Subtitles    int   `db:"subtitles"`
+		ContentBytes int64 `db:"content_bytes"`
+	}
+	_, err = r.db.From("subtitles").
+		Select(
+			goqu.COUNT("*").As("subtitles"),
+			goqu.L("COALESCE(SUM(length(CAST(content AS BLOB)) + length(CAST(original_content AS BLOB))), 0)").As("content_bytes"),
+		).
+		Where(goqu.C("video_id").In(activeVideoIDs(r.db))).
+		ScanStructContext(ctx, &totals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count subtitles: %w", err)
+	}
+	stats.Subtitles = totals.Subtitles
+	stats.ContentBytes = totals.ContentBytes
+
+	stats.Languages, err = r.CountSubtitlesByLanguage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// DatabaseStats reports the size of the database and WAL files and how many rows each table holds
+func (r *Repository) DatabaseStats(ctx context.Context) (*DatabaseStats, error) {
+	defer observeDBOperation("database_stats", time.Now())
+
+	var file string
+	var pageSize, freePages int64
+	err := r.db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database file: %w", err)
+	}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return nil, fmt.Errorf("failed to get page size: %w", err)
+	}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return nil, fmt.Errorf("failed to get free page count: %w", err)
+	}
+
+	stats := DatabaseStats{
+		FreeBytes: pageSize * freePages,
+		Rows:      map[string]int64{},
+	}
+
+	// In-memory databases have no file
+	if file != "" {
+		if stats.SizeBytes, err = fileSize(file); err != nil {
+			return nil, err
+		}
+		if stats.WALSizeBytes, err = fileSize(file + "-wal"); err != nil {
+			return nil, err
+		}
+	}
 
+	for _, table := range []string{"videos", "subtitles"} {
+		count, err := r.db.From(table).CountContext(ctx)
 		if err != nil {
-			slog.Warn("Failed to get subtitles for video",
-				"video_id", video.ID,
-				"error", err)
-			subtitles = []Subtitle{}
+			return nil, fmt.Errorf("failed to count %s: %w", table, err)
+		}
+		stats.Rows[table] = count
+	}
+
+	return &stats, nil
+}
+
+// MaintenanceResult reports what a maintenance run reclaimed
+type MaintenanceResult struct {
+	// ReclaimedBytes is the sum of the freed pages and the WAL truncation
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	FreedPages     int64 `json:"freed_pages"`
+	// IncrementalVacuum is false for databases created before auto_vacuum=INCREMENTAL was in
+	// effect, whose free pages only a full VACUUM returns
+	IncrementalVacuum bool  `json:"incremental_vacuum"`
+	WALBytesBefore    int64 `json:"wal_bytes_before"`
+	WALBytesAfter     int64 `json:"wal_bytes_after"`
+	// CheckpointBusy is set when readers or writers kept the WAL from being fully checkpointed
+	CheckpointBusy bool `json:"checkpoint_busy"`
+	Analyzed       bool `json:"analyzed"`
+}
+
+// ErrMaintenanceRunning is returned when maintenance is started while another run is in progress
+var ErrMaintenanceRunning = errors.New("maintenance is already running")
+
+// maintenanceMu keeps maintenance runs from overlapping
+var maintenanceMu sync.Mutex
+
+// Maintain returns free pages to the filesystem with an incremental vacuum and truncates the
+// WAL, then refreshes the query planner's statistics if analyze is set. Other connections
+// keep working meanwhile: each step takes the database's locks only as long as it needs
+// them, and the checkpoint gives up on pages that active readers still use.
+func (r *Repository) Maintain(ctx context.Context, analyze bool) (*MaintenanceResult, error) {
+	defer observeDBOperation("maintain", time.Now())
+
+	if !maintenanceMu.TryLock() {
+		return nil, ErrMaintenanceRunning
+	}
+	defer maintenanceMu.Unlock()
+
+	var file string
+	var pageSize, freeBefore, freeAfter, autoVacuum int64
+	if err := r.db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file); err != nil {
+		return nil, fmt.Errorf("failed to get database file: %w", err)
+	}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return nil, fmt.Errorf("failed to get page size: %w", err)
+	}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freeBefore); err != nil {
+		return nil, fmt.Errorf("failed to get free page count: %w", err)
+	}
+
+	var result MaintenanceResult
+	var err error
+	if file != "" {
+		if result.WALBytesBefore, err = fileSize(file + "-wal"); err != nil {
+			return nil, err
 		}
+	}
 
-		if subtitles == nil {
-			subtitles = []Subtitle{}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		return nil, fmt.Errorf("failed to get auto-vacuum mode: %w", err)
+	}
+	// 2 is INCREMENTAL, incremental_vacuum is a no-op in the other modes
+	result.IncrementalVacuum = autoVacuum == 2
+	if result.IncrementalVacuum {
+		// The pragma frees a page per step, so it has to be read to the end
+		rows, err := r.db.QueryContext(ctx, "PRAGMA incremental_vacuum")
+		if err != nil {
+			return nil, fmt.Errorf("failed to vacuum: %w", err)
+		}
+		for rows.Next() {
 		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to vacuum: %w", err)
+		}
+		if err := r.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freeAfter); err != nil {
+			return nil, fmt.Errorf("failed to get free page count: %w", err)
+		}
+		result.FreedPages = freeBefore - freeAfter
+	}
 
-		result = append(result, VideoWithSubs{
-			Video:     video,
-			Subtitles: subtitles,
-		})
+	if analyze {
+		if _, err := r.db.ExecContext(ctx, "ANALYZE"); err != nil {
+			return nil, fmt.Errorf("failed to analyze: %w", err)
+		}
+		result.Analyzed = true
 	}
 
-	return result, nil
+	// Checkpoint last, so the WAL written by the steps above is folded back too
+	var busy, logFrames, checkpointed int
+	if err := r.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return nil, fmt.Errorf("failed to checkpoint WAL: %w", err)
+	}
+	result.CheckpointBusy = busy != 0
+
+	if file != "" {
+		if result.WALBytesAfter, err = fileSize(file + "-wal"); err != nil {
+			return nil, err
+		}
+	}
+
+	result.ReclaimedBytes = result.FreedPages * pageSize
+	if result.WALBytesBefore > result.WALBytesAfter {
+		result.ReclaimedBytes += result.WALBytesBefore - result.WALBytesAfter
+	}
+
+	return &result, nil
+}
+
+// fileSize returns the size of a file, or 0 if it doesn't exist
+func fileSize(name string) (int64, error) {
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	return info.Size(), nil
+}
+
+// isBusyError reports whether err means the database was busy or locked by another connection
+func isBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	// Extended result codes keep the primary code in the low byte
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// execWithRetry runs a write, retrying with exponential backoff while the database is busy,
+// even after busy_timeout. Inside a transaction (conn is nil) the write runs once, since
+// retrying a single statement can't help when the transaction itself lost the lock
+func (r *Repository) execWithRetry(ctx context.Context, executor exec.QueryExecutor) (sql.Result, error) {
+	if r.conn == nil {
+		return executor.ExecContext(ctx)
+	}
+
+	var result sql.Result
+	err := r.retryBusy(ctx, "write", func() error {
+		var err error
+		result, err = executor.ExecContext(ctx)
+		return err
+	})
+	return result, err
+}
+
+// retryBusy runs fn until it doesn't fail with a busy error, up to busyAttempts times,
+// doubling the wait between attempts
+func (r *Repository) retryBusy(ctx context.Context, operation string, fn func() error) error {
+	delay := busyRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isBusyError(err) || attempt >= r.busyAttempts {
+			return err
+		}
+
+		slog.Warn("Database busy, retrying "+operation, "attempt", attempt, "delay", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isUniqueConstraintError reports whether err is a SQLite UNIQUE constraint violation
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
 }
 
-// CreateVideo inserts a new video and returns its ID
-func (r *Repository) CreateVideo(ctx context.Context, url, title string) (int64, error) {
-	result, err := r.db.Insert("videos").
-		Rows(goqu.Record{"original_url": url, "title": title}).
-		Executor().
-		ExecContext(ctx)
+// CreateVideo inserts a new video and returns its ID, or ErrVideoExists if a video with
+// the same video ID exists
+func (r *Repository) CreateVideo(ctx context.Context, videoID, url, title string) (int64, error) {
+	defer observeDBOperation("create_video", time.Now())
+
+	// SQLite's DO NOTHING can't name a conflict target, but original_url is the canonical
+	// URL of video_id, so a conflict on either means the video exists
+	now := time.Now().UTC()
+	result, err := r.execWithRetry(ctx, r.db.Insert("videos").
+		Rows(goqu.Record{
+			"video_id":     videoID,
+			"original_url": url,
+			"title":        title,
+			"created_at":   now,
+			"updated_at":   now,
+		}).
+		OnConflict(goqu.DoNothing()).
+		Executor())
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to insert video: %w", err)
 	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	} else if affected == 0 {
+		return 0, ErrVideoExists
+	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
@@ -206,45 +922,454 @@ func (r *Repository) CreateVideo(ctx context.Context, url, title string) (int64,
 	return id, nil
 }
 
-// DeleteVideo removes a video by ID
+// UpsertVideo inserts a video, or updates the title of the existing one with the same video ID
+// (restoring it if soft-deleted), and returns its ID
+func (r *Repository) UpsertVideo(ctx context.Context, videoID, url, title string) (int64, error) {
+	defer observeDBOperation("upsert_video", time.Now())
+
+	now := time.Now().UTC()
+	_, err := r.execWithRetry(ctx, r.db.Insert("videos").
+		Rows(goqu.Record{
+			"video_id":     videoID,
+			"original_url": url,
+			"title":        title,
+			"created_at":   now,
+			"updated_at":   now,
+		}).
+		OnConflict(goqu.DoUpdate("video_id", goqu.Record{
+			"original_url": goqu.L("excluded.original_url"),
+			"title":        goqu.L("excluded.title"),
+			"updated_at":   goqu.L("excluded.updated_at"),
+			"deleted_at":   nil,
+		})).
+		Executor())
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to upsert video: %w", err)
+	}
+
+	// The last insert ID isn't updated when the conflict clause updates a row
+	var id int64
+	_, err = r.db.From("videos").
+		Select("id").
+		Where(goqu.C("video_id").Eq(videoID)).
+		ScanValContext(ctx, &id)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to query upserted video: %w", err)
+	}
+
+	return id, nil
+}
+
+// UpdateVideo changes a video's title
+func (r *Repository) UpdateVideo(ctx context.Context, id int, title string) error {
+	defer observeDBOperation("update_video", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Update("videos").
+		Set(goqu.Record{
+			"title":      title,
+			"updated_at": time.Now().UTC(),
+		}).
+		Where(goqu.C("id").Eq(id), goqu.C("deleted_at").IsNull()).
+		Executor())
+
+	if err != nil {
+		return fmt.Errorf("failed to update video: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListDeletedVideos retrieves soft-deleted videos with their subtitles, most recently deleted first
+func (r *Repository) ListDeletedVideos(ctx context.Context) ([]VideoWithSubs, error) {
+	defer observeDBOperation("list_deleted_videos", time.Now())
+
+	var videos []Video
+	err := r.db.From("videos").
+		Select("id", "video_id", "original_url", "title", "created_at", "updated_at", "deleted_at", videoThumbnailURL).
+		Where(goqu.C("deleted_at").IsNotNull()).
+		Order(goqu.C("deleted_at").Desc(), goqu.C("id").Desc()).
+		ScanStructsContext(ctx, &videos)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deleted videos: %w", err)
+	}
+
+	return r.withSubtitles(ctx, videos)
+}
+
+// DeleteVideo soft-deletes a video, hiding it and its subtitles until it's restored or purged
 func (r *Repository) DeleteVideo(ctx context.Context, id int) error {
-	_, err := r.db.Delete("videos").
-		Where(goqu.C("id").Eq(id)).
-		Executor().
-		ExecContext(ctx)
+	defer observeDBOperation("delete_video", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Update("videos").
+		Set(goqu.Record{"deleted_at": time.Now().UTC()}).
+		Where(goqu.C("id").Eq(id), goqu.C("deleted_at").IsNull()).
+		Executor())
 
 	if err != nil {
 		return fmt.Errorf("failed to delete video: %w", err)
 	}
 
+	return requireAffected(result)
+}
+
+// RestoreVideo undoes a soft delete
+func (r *Repository) RestoreVideo(ctx context.Context, id int) error {
+	defer observeDBOperation("restore_video", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Update("videos").
+		Set(goqu.Record{"deleted_at": nil}).
+		Where(goqu.C("id").Eq(id), goqu.C("deleted_at").IsNotNull()).
+		Executor())
+
+	if err != nil {
+		return fmt.Errorf("failed to restore video: %w", err)
+	}
+
+	return requireAffected(result)
+}
+
+// PurgeVideo permanently removes a video, deleted or not, along with its subtitles
+func (r *Repository) PurgeVideo(ctx context.Context, id int) error {
+	defer observeDBOperation("purge_video", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Delete("videos").
+		Where(goqu.C("id").Eq(id)).
+		Executor())
+
+	if err != nil {
+		return fmt.Errorf("failed to purge video: %w", err)
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteVideos soft-deletes the given videos in one statement, returning how many were
+// deleted. IDs of missing or already deleted videos are ignored
+func (r *Repository) DeleteVideos(ctx context.Context, ids []int) (int64, error) {
+	defer observeDBOperation("delete_videos", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Update("videos").
+		Set(goqu.Record{"deleted_at": time.Now().UTC()}).
+		Where(goqu.C("id").In(ids), goqu.C("deleted_at").IsNull()).
+		Executor())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete videos: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return deleted, nil
+}
+
+// PurgeVideos permanently removes the given videos, deleted or not, along with their
+// subtitles, returning how many were removed
+func (r *Repository) PurgeVideos(ctx context.Context, ids []int) (int64, error) {
+	defer observeDBOperation("purge_videos", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Delete("videos").
+		Where(goqu.C("id").In(ids)).
+		Executor())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge videos: %w", err)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return purged, nil
+}
+
+// requireAffected returns sql.ErrNoRows if a statement didn't change any rows
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
-// CreateSubtitle inserts a new subtitle
-func (r *Repository) CreateSubtitle(ctx context.Context, videoID int, language, subType, content string) error {
-	_, err := r.db.Insert("subtitles").
-		Rows(goqu.Record{
-			"video_id": videoID,
-			"language": language,
-			"type":     subType,
-			"content":  content,
-		}).
-		Executor().
-		ExecContext(ctx)
+// languageRank ranks a subtitle's language by its position in languageOrder, ranking
+// languages that aren't listed last
+func languageRank(languageOrder []string) exp.LiteralExpression {
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(languageOrder)*2+1)
+	sb.WriteString("CASE language")
+	for i, language := range languageOrder {
+		sb.WriteString(" WHEN ? THEN ?")
+		args = append(args, language, i)
+	}
+	sb.WriteString(" ELSE ? END")
+	args = append(args, len(languageOrder))
+
+	return goqu.L(sb.String(), args...)
+}
 
+// activeVideoIDs selects the IDs of videos that haven't been soft-deleted
+func activeVideoIDs(db dbHandle) *goqu.SelectDataset {
+	return db.From("videos").Select("id").Where(goqu.C("deleted_at").IsNull())
+}
+
+// CreateSubtitle inserts a new subtitle along with the original upload it was converted from, and returns its ID
+func (r *Repository) CreateSubtitle(ctx context.Context, videoID int, language, subType, content, originalType, originalContent string) (int64, error) {
+	defer observeDBOperation("create_subtitle", time.Now())
+
+	record, err := subtitleContentRecord(content, originalContent, r.compressSubtitles)
 	if err != nil {
-		return fmt.Errorf("failed to insert subtitle: %w", err)
+		return 0, err
 	}
+	now := time.Now().UTC()
+	record["video_id"] = videoID
+	record["language"] = language
+	record["type"] = subType
+	record["original_type"] = originalType
+	record["created_at"] = now
+	record["updated_at"] = now
+
+	var id int64
+	err = r.WithTx(ctx, func(tx *Repository) error {
+		if err := tx.checkSubtitleLimit(ctx, videoID); err != nil {
+			return err
+		}
+
+		result, err := tx.execWithRetry(ctx, tx.db.Insert("subtitles").
+			Rows(record).
+			Executor())
 
+		if isUniqueConstraintError(err) {
+			return ErrSubtitleExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to insert subtitle: %w", err)
+		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get last insert id: %w", err)
+		}
+		return nil
+	})
+
+	return id, err
+}
+
+// checkSubtitleLimit returns ErrTooManySubtitles if a video can't get another subtitle.
+// It should run in the same transaction as the insert, so concurrent inserts can't both pass
+func (r *Repository) checkSubtitleLimit(ctx context.Context, videoID int) error {
+	if r.maxSubtitlesPerVideo <= 0 {
+		return nil
+	}
+
+	counts, err := r.CountSubtitlesByVideo(ctx, []int{videoID})
+	if err != nil {
+		return err
+	}
+	if counts[videoID] >= r.maxSubtitlesPerVideo {
+		return ErrTooManySubtitles
+	}
 	return nil
 }
 
+// ReplaceSubtitle inserts a subtitle, overwriting the content of an existing one
+// with the same video, language and type, and returns its ID
+func (r *Repository) ReplaceSubtitle(ctx context.Context, videoID int, language, subType, content, originalType, originalContent string) (int64, error) {
+	defer observeDBOperation("replace_subtitle", time.Now())
+
+	record, err := subtitleContentRecord(content, originalContent, r.compressSubtitles)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	record["video_id"] = videoID
+	record["language"] = language
+	record["type"] = subType
+	record["original_type"] = originalType
+	record["created_at"] = now
+	record["updated_at"] = now
+
+	var id int64
+	err = r.WithTx(ctx, func(tx *Repository) error {
+		existing := tx.db.From("subtitles").
+			Select("id").
+			Where(goqu.C("video_id").Eq(videoID), goqu.C("language").Eq(language), goqu.C("type").Eq(subType))
+
+		// Overwriting an existing subtitle doesn't count towards the limit
+		found, err := existing.ScanValContext(ctx, &id)
+		if err != nil {
+			return fmt.Errorf("failed to query subtitle: %w", err)
+		}
+		if !found {
+			if err := tx.checkSubtitleLimit(ctx, videoID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.execWithRetry(ctx, tx.db.Insert("subtitles").
+			Rows(record).
+			OnConflict(goqu.DoUpdate("video_id, language, type", goqu.Record{
+				"content":          goqu.L("excluded.content"),
+				"original_type":    goqu.L("excluded.original_type"),
+				"original_content": goqu.L("excluded.original_content"),
+				"compressed":       goqu.L("excluded.compressed"),
+				"line_count":       goqu.L("excluded.line_count"),
+				"char_count":       goqu.L("excluded.char_count"),
+				"cue_count":        goqu.L("excluded.cue_count"),
+				"duration_ms":      goqu.L("excluded.duration_ms"),
+				"updated_at":       goqu.L("excluded.updated_at"),
+			})).
+			Executor())
+
+		if err != nil {
+			return fmt.Errorf("failed to replace subtitle: %w", err)
+		}
+
+		// The last insert ID isn't updated when the conflict clause updates a row
+		if _, err := existing.ScanValContext(ctx, &id); err != nil {
+			return fmt.Errorf("failed to query replaced subtitle: %w", err)
+		}
+		return nil
+	})
+
+	return id, err
+}
+
+// UpdateSubtitle replaces a subtitle's content, and its language if one is given
+func (r *Repository) UpdateSubtitle(ctx context.Context, id int, language, content string) error {
+	defer observeDBOperation("update_subtitle", time.Now())
+
+	// Content and the original upload share the compressed flag, so both are rewritten
+	return r.WithTx(ctx, func(tx *Repository) error {
+		var original Subtitle
+		found, err := tx.db.From("subtitles").
+			Select("id", "original_content", "compressed").
+			Where(goqu.C("id").Eq(id)).
+			ScanStructContext(ctx, &original)
+		if err != nil {
+			return fmt.Errorf("failed to query subtitle: %w", err)
+		}
+		if !found {
+			return sql.ErrNoRows
+		}
+		if err := decompressSubtitle(&original); err != nil {
+			return err
+		}
+
+		record, err := subtitleContentRecord(content, original.OriginalContent, tx.compressSubtitles)
+		if err != nil {
+			return err
+		}
+		record["updated_at"] = time.Now().UTC()
+		if language != "" {
+			record["language"] = language
+		}
+
+		_, err = tx.execWithRetry(ctx, tx.db.Update("subtitles").
+			Set(record).
+			Where(goqu.C("id").Eq(id)).
+			Executor())
+
+		if isUniqueConstraintError(err) {
+			return ErrSubtitleExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update subtitle: %w", err)
+		}
+		return nil
+	})
+}
+
+// MoveSubtitle reassigns a subtitle to another video, returning ErrSubtitleExists
+// if that video already has a subtitle with the same language and type, or
+// ErrTooManySubtitles if it has no room for another one
+func (r *Repository) MoveSubtitle(ctx context.Context, id, videoID int) error {
+	defer observeDBOperation("move_subtitle", time.Now())
+
+	return r.WithTx(ctx, func(tx *Repository) error {
+		var currentVideoID int
+		found, err := tx.db.From("subtitles").
+			Select("video_id").
+			Where(goqu.C("id").Eq(id)).
+			ScanValContext(ctx, &currentVideoID)
+		if err != nil {
+			return fmt.Errorf("failed to query subtitle: %w", err)
+		}
+		if !found {
+			return sql.ErrNoRows
+		}
+
+		// A subtitle staying on its own video doesn't add to it
+		if currentVideoID != videoID {
+			if err := tx.checkSubtitleLimit(ctx, videoID); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.execWithRetry(ctx, tx.db.Update("subtitles").
+			Set(goqu.Record{
+				"video_id":   videoID,
+				"updated_at": time.Now().UTC(),
+			}).
+			Where(goqu.C("id").Eq(id)).
+			Executor())
+
+		if isUniqueConstraintError(err) {
+			return ErrSubtitleExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to move subtitle: %w", err)
+		}
+
+		return requireAffected(result)
+	})
+}
+
+// RenameSubtitle changes a subtitle's language, returning ErrSubtitleExists if its video
+// already has a subtitle with that language and type
+func (r *Repository) RenameSubtitle(ctx context.Context, id int, language string) error {
+	defer observeDBOperation("rename_subtitle", time.Now())
+
+	result, err := r.execWithRetry(ctx, r.db.Update("subtitles").
+		Set(goqu.Record{
+			"language":   language,
+			"updated_at": time.Now().UTC(),
+		}).
+		Where(goqu.C("id").Eq(id)).
+		Executor())
+
+	if isUniqueConstraintError(err) {
+		return ErrSubtitleExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rename subtitle: %w", err)
+	}
+
+	return requireAffected(result)
+}
+
 // DeleteSubtitle removes a subtitle by ID
 func (r *Repository) DeleteSubtitle(ctx context.Context, id int) error {
-	_, err := r.db.Delete("subtitles").
+	defer observeDBOperation("delete_subtitle", time.Now())
+
+	_, err := r.execWithRetry(ctx, r.db.Delete("subtitles").
 		Where(goqu.C("id").Eq(id)).
-		Executor().
-		ExecContext(ctx)
+		Executor())
 
 	if err != nil {
 		return fmt.Errorf("failed to delete subtitle: %w", err)
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+func TestCreateVideoConflictsOnVideoID(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	id, err := repo.CreateVideo(ctx, "dQw4w9WgXcQ", youtubeCanonicalURL("dQw4w9WgXcQ"), "First")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A different URL for the same video still conflicts
+	_, err = repo.CreateVideo(ctx, "dQw4w9WgXcQ", "https://youtu.be/dQw4w9WgXcQ", "Second")
+	if !errors.Is(err, ErrVideoExists) {
+		t.Fatalf("CreateVideo with a duplicate video ID returned %v, want ErrVideoExists", err)
+	}
+
+	upserted, err := repo.UpsertVideo(ctx, "dQw4w9WgXcQ", youtubeCanonicalURL("dQw4w9WgXcQ"), "Renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upserted != id {
+		t.Errorf("UpsertVideo returned ID %d, want %d", upserted, id)
+	}
+
+	video, err := repo.GetVideoByURL(ctx, "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Renamed" {
+		t.Errorf("title is %q after upsert, want %q", video.Title, "Renamed")
+	}
+}
+
+func TestSubtitleLimit(t *testing.T) {
+	repo := newTestRepository(t)
+	repo.maxSubtitlesPerVideo = 2
+	ctx := context.Background()
+	ids := seedSubtitles(t, repo, 2, "en", "fr")
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+
+	if _, err := repo.CreateSubtitle(ctx, ids[0], "de", "srt", srt, "srt", srt); !errors.Is(err, ErrTooManySubtitles) {
+		t.Errorf("CreateSubtitle past the limit returned %v, want ErrTooManySubtitles", err)
+	}
+	if _, err := repo.ReplaceSubtitle(ctx, ids[0], "de", "srt", srt, "srt", srt); !errors.Is(err, ErrTooManySubtitles) {
+		t.Errorf("ReplaceSubtitle of a new language past the limit returned %v, want ErrTooManySubtitles", err)
+	}
+	if _, err := repo.ReplaceSubtitle(ctx, ids[0], "en", "srt", srt, "srt", srt); err != nil {
+		t.Errorf("ReplaceSubtitle of an existing language at the limit returned %v", err)
+	}
+
+	moved, err := repo.GetSubtitleByLanguage(ctx, ids[1], "fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MoveSubtitle(ctx, moved.ID, ids[0]); !errors.Is(err, ErrTooManySubtitles) {
+		t.Errorf("MoveSubtitle to a full video returned %v, want ErrTooManySubtitles", err)
+	}
+
+	// Moving a subtitle to the full video it's already on doesn't add to it
+	kept, err := repo.GetSubtitleByLanguage(ctx, ids[0], "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MoveSubtitle(ctx, kept.ID, ids[0]); err != nil {
+		t.Errorf("MoveSubtitle to its own full video returned %v", err)
+	}
+}
+
+func TestSubtitleLimitConcurrentInserts(t *testing.T) {
+	repo := newTestRepository(t)
+	repo.maxSubtitlesPerVideo = 3
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1)[0]
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+
+	languages := []string{"en", "fr", "de", "es", "it", "pt", "nl", "sv", "ja", "ko"}
+	errs := make([]error, len(languages))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, language := range languages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, errs[i] = repo.CreateSubtitle(ctx, videoID, language, "srt", srt, "srt", srt)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrTooManySubtitles):
+			t.Errorf("CreateSubtitle(%s) returned %v", languages[i], err)
+		}
+	}
+	if created != 3 {
+		t.Errorf("%d concurrent inserts succeeded, want 3", created)
+	}
+
+	counts, err := repo.CountSubtitlesByVideo(ctx, []int{videoID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[videoID] != 3 {
+		t.Errorf("video has %d subtitles, want 3", counts[videoID])
+	}
+}
+
+// seedSubtitles creates videos with a subtitle in each of languages
+func seedSubtitles(t testing.TB, repo *Repository, videos int, languages ...string) []int {
+	t.Helper()
+	ctx := context.Background()
+
+	ids := make([]int, 0, videos)
+	for i := range videos {
+		videoID := fmt.Sprintf("video%06d", i)
+		id, err := repo.CreateVideo(ctx, videoID, youtubeCanonicalURL(videoID), videoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, language := range languages {
+			srt := "1\n00:00:01,000 --> 00:00:02,000\n" + language + "\n"
+			if _, err := repo.CreateSubtitle(ctx, int(id), language, "srt", srt, "srt", srt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, int(id))
+	}
+	return ids
+}
+
+func TestSubtitlesByVideoIDUsesIndex(t *testing.T) {
+	repo := newTestRepository(t)
+	ids := seedSubtitles(t, repo, 50, "en", "fr")
+	ctx := context.Background()
+
+	if _, err := repo.conn.ExecContext(ctx, "ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The filter GetSubtitlesByVideoID and the per-video subtitle queries share
+	query, args, err := repo.db.From("subtitles").
+		Select("id", "video_id", "language", "type", "content", "original_type", "compressed").
+		Where(goqu.C("video_id").Eq(ids[0])).
+		ToSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := repo.conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Either idx_subtitles_video_id or the unique index led by video_id avoids a full scan
+	joined := strings.Join(plan, "; ")
+	if !strings.Contains(joined, "SEARCH subtitles USING") || !strings.Contains(joined, "(video_id=?)") ||
+		strings.Contains(joined, "SCAN subtitles") {
+		t.Errorf("query plan doesn't search by a video_id index: %s", joined)
+	}
+}
+
+func BenchmarkGetSubtitlesByVideoID(b *testing.B) {
+	repo, err := NewRepository(b.TempDir()+"/bench.db", DefaultDatabaseConfig)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer repo.Close()
+	ids := seedSubtitles(b, repo, 500, "en", "fr", "de")
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.GetSubtitlesByVideoID(ctx, ids[i%len(ids)], nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// rerunMigration applies the migration with the given description again, on top of
+// whatever rows the test stored the way older versions did
+func rerunMigration(t *testing.T, repo *Repository, description string) {
+	t.Helper()
+
+	for _, m := range migrations {
+		if m.description == description {
+			if err := applyMigration(repo.conn.Db.(*sql.DB), len(migrations)+1, m); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatalf("migration %q not found", description)
+}
+
+func TestMigrationMergesDuplicateVideos(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	keeper := seedSubtitles(t, repo, 1, "en", "fr")[0]
+
+	// Added twice under different URLs before video_id was unique
+	db := repo.conn
+	if _, err := db.ExecContext(ctx, "DROP INDEX idx_videos_video_id"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.ExecContext(ctx, `INSERT INTO videos (original_url, video_id, title, created_at, updated_at)
+		VALUES ('https://youtu.be/video000000', 'video000000', 'Duplicate', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicate, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, language := range []string{"en", "de"} {
+		if _, err := db.ExecContext(ctx, "INSERT INTO subtitles (video_id, language, type, content) VALUES (?, ?, 'srt', '')", duplicate, language); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rerunMigration(t, repo, "canonicalize video URLs and make videos.video_id unique")
+
+	video, err := repo.GetVideoByURL(ctx, "video000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ID != keeper {
+		t.Errorf("video000000 is video %d, want %d", video.ID, keeper)
+	}
+
+	// The duplicate is soft-deleted, keeping the subtitle the kept video already had
+	if _, err := repo.GetVideoByID(ctx, int(duplicate)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetVideoByID of the duplicate returned %v, want sql.ErrNoRows", err)
+	}
+	want := map[int][]string{
+		keeper:         {"de", "en", "fr"},
+		int(duplicate): {"en"},
+	}
+	for videoID, languages := range want {
+		var got []string
+		err := db.From("subtitles").Select("language").Where(goqu.C("video_id").Eq(videoID)).
+			Order(goqu.C("language").Asc()).ScanValsContext(ctx, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(languages, ",") {
+			t.Errorf("video %d has languages %v, want %v", videoID, got, languages)
+		}
+	}
+
+	if err := repo.RestoreVideo(ctx, int(duplicate)); err != nil {
+		t.Errorf("RestoreVideo of the duplicate returned %v", err)
+	}
+}
+
+func TestMigrationNormalizesLanguages(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	ids := seedSubtitles(t, repo, 2)
+
+	// Stored before languages were normalized on write
+	db := repo.conn
+	for _, language := range []string{"EN", "english", "en", "French"} {
+		if _, err := db.ExecContext(ctx, "INSERT INTO subtitles (video_id, language, type, content) VALUES (?, ?, 'srt', '')", ids[0], language); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, language := range []string{"English", "pt_BR", "not a language"} {
+		if _, err := db.ExecContext(ctx, "INSERT INTO subtitles (video_id, language, type, content) VALUES (?, ?, 'srt', '')", ids[1], language); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rerunMigration(t, repo, "normalize subtitles.language")
+
+	want := map[int][]string{
+		// The subtitle already stored as "en" keeps it, the others keep what they had
+		ids[0]: {"EN", "en", "english", "fr"},
+		ids[1]: {"en", "not a language", "pt-br"},
+	}
+	for videoID, languages := range want {
+		var got []string
+		err := db.From("subtitles").Select("language").Where(goqu.C("video_id").Eq(videoID)).
+			Order(goqu.C("language").Asc()).ScanValsContext(ctx, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != strings.Join(languages, ",") {
+			t.Errorf("video %d has languages %v, want %v", videoID, got, languages)
+		}
+	}
+}
+
+func TestDeleteVideos(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	ids := seedSubtitles(t, repo, 3, "en", "fr")
+
+	subtitles := make(map[int][]Subtitle, len(ids))
+	for _, id := range ids {
+		subs, err := repo.ListSubtitleMetadata(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subs) != 2 {
+			t.Fatalf("video %d has %d subtitles, want 2", id, len(subs))
+		}
+		subtitles[id] = subs
+	}
+
+	// Unknown IDs are ignored rather than failing the batch
+	deleted, err := repo.DeleteVideos(ctx, []int{ids[0], 999999, ids[1], -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeleteVideos returned %d, want 2", deleted)
+	}
+
+	// Videos that are already deleted don't count again
+	deleted, err = repo.DeleteVideos(ctx, []int{ids[0], ids[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("DeleteVideos of deleted videos returned %d, want 0", deleted)
+	}
+
+	for i, id := range ids {
+		wantDeleted := i < 2
+		if _, err := repo.GetVideoByID(ctx, id); errors.Is(err, sql.ErrNoRows) != wantDeleted {
+			t.Errorf("GetVideoByID(%d) returned %v, deleted %v", id, err, wantDeleted)
+		}
+		for _, sub := range subtitles[id] {
+			if _, err := repo.GetSubtitleByID(ctx, sub.ID); errors.Is(err, sql.ErrNoRows) != wantDeleted {
+				t.Errorf("GetSubtitleByID(%d) of video %d returned %v, deleted %v", sub.ID, id, err, wantDeleted)
+			}
+		}
+	}
+
+	counts, err := repo.CountSubtitlesByLanguage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, count := range counts {
+		if count.Count != 1 {
+			t.Errorf("%d %s subtitles are listed, want 1", count.Count, count.Language)
+		}
+	}
+
+	// Soft-deleted subtitles come back with their video
+	if err := repo.RestoreVideo(ctx, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range subtitles[ids[0]] {
+		if _, err := repo.GetSubtitleByID(ctx, sub.ID); err != nil {
+			t.Errorf("GetSubtitleByID(%d) after restore returned %v", sub.ID, err)
+		}
+	}
+}
+
+// newBusyTestRepository opens a test database that reports busy errors right away instead
+// of waiting in busy_timeout, along with a function that holds the write lock until released
+func newBusyTestRepository(t *testing.T) (*Repository, func() (release func())) {
+	t.Helper()
+
+	cfg := DefaultDatabaseConfig
+	cfg.BusyTimeout = time.Millisecond
+	cfg.BusyAttempts = 5
+	repo, err := NewRepository(filepath.Join(t.TempDir(), "test.db"), cfg)
+	if err != nil {
+		t.Fatalf("NewRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	lock := func() func() {
+		// Transactions begin immediate, so an open one holds the write lock
+		tx, err := repo.conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return func() {
+			if err := tx.Rollback(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	return repo, lock
+}
+
+func TestCreateSubtitleRetriesWhileLocked(t *testing.T) {
+	repo, lock := newBusyTestRepository(t)
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1)[0]
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+
+	// A single attempt fails while another connection holds the lock
+	release := lock()
+	repo.busyAttempts = 1
+	if _, err := repo.CreateSubtitle(ctx, videoID, "en", "srt", srt, "srt", srt); !isBusyError(err) {
+		t.Fatalf("CreateSubtitle with the database locked returned %v, want a busy error", err)
+	}
+
+	// With retries, it succeeds once the lock is released
+	repo.busyAttempts = 5
+	time.AfterFunc(80*time.Millisecond, release)
+	id, err := repo.CreateSubtitle(ctx, videoID, "en", "srt", srt, "srt", srt)
+	if err != nil {
+		t.Fatalf("CreateSubtitle after the lock was released returned %v", err)
+	}
+	if _, err := repo.GetSubtitleByID(ctx, int(id)); err != nil {
+		t.Errorf("GetSubtitleByID(%d): %v", id, err)
+	}
+}
+
+func TestUpdateSubtitleRetriesWhileLocked(t *testing.T) {
+	repo, lock := newBusyTestRepository(t)
+	repo.compressSubtitles = true
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1, "en")[0]
+
+	stored, err := repo.GetSubtitleByLanguage(ctx, videoID, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := lock()
+	time.AfterFunc(80*time.Millisecond, release)
+	updated := "1\n00:00:01,000 --> 00:00:02,000\nUpdated\n"
+	if err := repo.UpdateSubtitle(ctx, stored.ID, "", updated); err != nil {
+		t.Fatalf("UpdateSubtitle after the lock was released returned %v", err)
+	}
+
+	subtitle, err := repo.GetSubtitleByID(ctx, stored.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subtitle.Content != updated {
+		t.Errorf("content is %q after update, want %q", subtitle.Content, updated)
+	}
+}
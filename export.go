@@ -0,0 +1,325 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// videoBatchSize is how many videos are loaded at a time when walking the whole library
+const videoBatchSize = 100
+
+// exportManifestName is the name of the manifest file at the root of an export archive
+const exportManifestName = "manifest.json"
+
+// ExportManifest describes the videos in an export archive
+type ExportManifest struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	Videos     []ExportedVideo `json:"videos"`
+}
+
+// ExportedVideo is a video's metadata in an export manifest, its subtitles live in Folder
+type ExportedVideo struct {
+	VideoID     string             `json:"video_id"`
+	OriginalURL string             `json:"original_url"`
+	Title       string             `json:"title"`
+	Folder      string             `json:"folder"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Subtitles   []ExportedSubtitle `json:"subtitles"`
+}
+
+// ExportedSubtitle points to a subtitle's SRT file within an export archive, and to the
+// original upload it was converted from when that differs
+type ExportedSubtitle struct {
+	Language     string    `json:"language"`
+	File         string    `json:"file"`
+	OriginalType string    `json:"original_type,omitempty"`
+	OriginalFile string    `json:"original_file,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func exportLibrary(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Attachment("subbed-export-" + time.Now().UTC().Format("20060102") + ".zip")
+
+		// The archive is written after the handler returns, so it can't use the request context
+		reqID := requestID(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := writeExport(context.Background(), repo, w); err != nil {
+				// Headers are already sent, the client is left with a truncated archive
+				slog.Error("Failed to export library", "request_id", reqID, "error", err)
+			}
+		})
+		return nil
+	}
+}
+
+// writeExport writes every active video's subtitles as a zip archive, one folder per video,
+// followed by a manifest. Videos are loaded in batches and flushed as they go to bound memory use
+func writeExport(ctx context.Context, repo *Repository, w *bufio.Writer) error {
+	zw := zip.NewWriter(w)
+	manifest := ExportManifest{
+		ExportedAt: time.Now().UTC(),
+		Videos:     []ExportedVideo{},
+	}
+	folders := map[string]bool{}
+
+	for afterID := 0; ; {
+		videos, err := repo.ListVideosAfterID(ctx, afterID, videoBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(videos) == 0 {
+			break
+		}
+		afterID = videos[len(videos)-1].ID
+
+		for _, video := range videos {
+			exported, err := writeExportedVideo(ctx, repo, zw, video, folders)
+			if err != nil {
+				return err
+			}
+			manifest.Videos = append(manifest.Videos, exported)
+
+			// Push the compressed data to the client instead of buffering it
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("failed to send archive: %w", err)
+			}
+		}
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     exportManifestName,
+		Method:   zip.Deflate,
+		Modified: manifest.ExportedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add manifest: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return w.Flush()
+}
+
+// writeExportedVideo adds a video's subtitles to the archive and returns its manifest entry
+func writeExportedVideo(ctx context.Context, repo *Repository, zw *zip.Writer, video Video, folders map[string]bool) (ExportedVideo, error) {
+	folder := exportFolderName(video)
+	if folders[folder] {
+		folder += "-" + strconv.Itoa(video.ID)
+	}
+	folders[folder] = true
+
+	exported := ExportedVideo{
+		VideoID:     video.VideoID,
+		OriginalURL: video.OriginalURL,
+		Title:       video.Title,
+		Folder:      folder,
+		CreatedAt:   video.CreatedAt,
+		UpdatedAt:   video.UpdatedAt,
+		Subtitles:   []ExportedSubtitle{},
+	}
+
+	metadata, err := repo.ListSubtitleMetadata(ctx, video.ID)
+	if err != nil {
+		return exported, err
+	}
+
+	files := map[string]bool{}
+	for _, meta := range metadata {
+		// Loaded one at a time, since the original uploads are kept along with the content
+		subtitle, err := repo.GetSubtitleByID(ctx, meta.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Deleted since the listing
+			continue
+		}
+		if err != nil {
+			return exported, err
+		}
+
+		// Languages that weren't validated when stored can clash once made safe as file names
+		name := exportLanguageName(subtitle.Language)
+		for n := 2; files[name]; n++ {
+			name = exportLanguageName(subtitle.Language) + "." + strconv.Itoa(n)
+		}
+		files[name] = true
+
+		entry := ExportedSubtitle{
+			Language:     subtitle.Language,
+			File:         folder + "/" + name + ".srt",
+			OriginalType: subtitle.OriginalType,
+			CreatedAt:    subtitle.CreatedAt,
+			UpdatedAt:    subtitle.UpdatedAt,
+		}
+		if err := writeArchiveFile(zw, entry.File, subtitle.Content, subtitle.UpdatedAt); err != nil {
+			return exported, err
+		}
+
+		// The original is only stored separately when it isn't the exported SRT itself
+		if subtitle.OriginalContent != "" && subtitle.OriginalContent != subtitle.Content {
+			entry.OriginalFile = folder + "/" + name + ".original." + exportLanguageName(subtitle.OriginalType)
+			if err := writeArchiveFile(zw, entry.OriginalFile, subtitle.OriginalContent, subtitle.UpdatedAt); err != nil {
+				return exported, err
+			}
+		}
+
+		exported.Subtitles = append(exported.Subtitles, entry)
+	}
+
+	return exported, nil
+}
+
+// writeArchiveFile adds a compressed file to an archive
+func writeArchiveFile(zw *zip.Writer, name, content string, modified time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// exportFolderName names a video's folder after its title and YouTube video ID, e.g. "my-video-dQw4w9WgXcQ"
+func exportFolderName(video Video) string {
+	videoID := video.VideoID
+	if videoID == "" {
+		videoID = strconv.Itoa(video.ID)
+	}
+	if slug := slugify(video.Title); slug != "" {
+		return slug + "-" + videoID
+	}
+	return videoID
+}
+
+// exportLanguageName makes a language safe to use as a file name, since languages stored before
+// validation was added may contain anything. The manifest keeps the original
+func exportLanguageName(language string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.Trim(language, "."))
+	if name == "" {
+		return "subtitle"
+	}
+	return name
+}
+
+// exportVideoSubtitles streams a zip of one video's subtitles, one file per language in the
+// format given by ?format= (srt or vtt)
+func exportVideoSubtitles(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		idInt, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		format := c.Query("format", "srt")
+		if format != "srt" && format != "vtt" {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid format, expected srt or vtt")
+		}
+
+		video, err := repo.GetVideoByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		subtitles, err := repo.ListSubtitleMetadata(ctx, video.ID)
+		if err != nil {
+			return err
+		}
+
+		c.Attachment(subtitleFilename(video.Title, "", "zip"))
+
+		// Content is loaded one subtitle at a time while the archive is written
+		reqID := requestID(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := writeVideoSubtitlesZip(context.Background(), repo, video, subtitles, format, w); err != nil {
+				slog.Error("Failed to export video subtitles", "request_id", reqID, "video_id", video.ID, "error", err)
+			}
+		})
+		return nil
+	}
+}
+
+// writeVideoSubtitlesZip writes the given subtitles as a zip archive, named like "my-video.en.vtt"
+func writeVideoSubtitlesZip(ctx context.Context, repo *Repository, video *Video, subtitles []Subtitle, format string, w *bufio.Writer) error {
+	zw := zip.NewWriter(w)
+	names := map[string]bool{}
+
+	for _, meta := range subtitles {
+		subtitle, err := repo.GetSubtitleByID(ctx, meta.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Deleted since the listing
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Languages that weren't validated when stored can clash once made safe as file names
+		language := exportLanguageName(subtitle.Language)
+		name := subtitleFilename(video.Title, language, format)
+		for n := 2; names[name]; n++ {
+			name = subtitleFilename(video.Title, language+"."+strconv.Itoa(n), format)
+		}
+		names[name] = true
+
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: subtitle.UpdatedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", name, err)
+		}
+		if format == "vtt" {
+			err = streamSRTToVTT(f, strings.NewReader(subtitle.Content))
+		} else {
+			_, err = io.WriteString(f, subtitle.Content)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to send archive: %w", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return w.Flush()
+}
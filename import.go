@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ImportResult reports the outcome of importing a single video from an archive
+type ImportResult struct {
+	VideoID   string `json:"video_id"`
+	Folder    string `json:"folder"`
+	Status    string `json:"status"` // created, updated, skipped or failed
+	Subtitles int    `json:"subtitles"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ImportSummary is the response of an archive import
+type ImportSummary struct {
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+// maxManifestSize bounds an archive's manifest, at a few hundred bytes per video it's room for
+// well over 100,000 videos
+const maxManifestSize = 64 * 1024 * 1024
+
+// errImportSkipped aborts a video's import transaction when the video already exists
+var errImportSkipped = errors.New("video already exists")
+
+func importLibrary(repo *Repository, maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "No file uploaded")
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer f.Close()
+
+		zr, err := zip.NewReader(f, file.Size)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid zip archive")
+		}
+
+		files := map[string]*zip.File{}
+		for _, zf := range zr.File {
+			files[zf.Name] = zf
+		}
+
+		manifestFile, ok := files[exportManifestName]
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Archive has no "+exportManifestName)
+		}
+		var manifest ExportManifest
+		if err := readArchiveJSON(manifestFile, &manifest, maxManifestSize); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid "+exportManifestName+": "+err.Error())
+		}
+
+		// With ?upsert=true, existing videos get their title and subtitles replaced instead of being skipped
+		upsert := c.QueryBool("upsert")
+
+		summary := ImportSummary{Results: make([]ImportResult, len(manifest.Videos))}
+		for i, video := range manifest.Videos {
+			result := importArchivedVideo(ctx, repo, files, video, upsert, maxSize)
+			switch result.Status {
+			case "created":
+				summary.Created++
+			case "updated":
+				summary.Updated++
+			case "skipped":
+				summary.Skipped++
+			default:
+				summary.Failed++
+			}
+			summary.Results[i] = result
+		}
+
+		return c.JSON(summary)
+	}
+}
+
+// importArchivedVideo recreates a video and its subtitles from an archive in a single transaction
+func importArchivedVideo(ctx context.Context, repo *Repository, files map[string]*zip.File, video ExportedVideo, upsert bool, maxSize int) ImportResult {
+	result := ImportResult{
+		VideoID: video.VideoID,
+		Folder:  video.Folder,
+		Status:  "failed",
+	}
+
+	videoID, ok := youtubeVideoIDFromURL(video.OriginalURL)
+	if !ok {
+		videoID, ok = youtubeVideoIDFromURL(video.VideoID)
+	}
+	if !ok {
+		result.Error = "invalid YouTube URL"
+		return result
+	}
+	result.VideoID = videoID
+
+	// Read and validate every subtitle before touching the database
+	subtitles := make([]Subtitle, 0, len(video.Subtitles))
+	for _, archived := range video.Subtitles {
+		subtitle, err := readArchivedSubtitle(files, archived, maxSize)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		subtitles = append(subtitles, subtitle)
+	}
+
+	var status string
+	err := repo.WithTx(ctx, func(tx *Repository) error {
+		status = "created"
+		url := youtubeCanonicalURL(videoID)
+		id, err := tx.CreateVideo(ctx, videoID, url, video.Title)
+		if errors.Is(err, ErrVideoExists) {
+			if !upsert {
+				return errImportSkipped
+			}
+			status = "updated"
+			id, err = tx.UpsertVideo(ctx, videoID, url, video.Title)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, sub := range subtitles {
+			if upsert {
+				_, err = tx.ReplaceSubtitle(ctx, int(id), sub.Language, sub.Type, sub.Content, sub.OriginalType, sub.OriginalContent)
+			} else {
+				_, err = tx.CreateSubtitle(ctx, int(id), sub.Language, sub.Type, sub.Content, sub.OriginalType, sub.OriginalContent)
+			}
+			if errors.Is(err, ErrSubtitleExists) {
+				return fmt.Errorf("duplicate %s subtitle: %w", sub.Language, err)
+			}
+			if errors.Is(err, ErrTooManySubtitles) {
+				return fmt.Errorf("more than %d subtitles: %w", tx.maxSubtitlesPerVideo, err)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errImportSkipped):
+		result.Status = "skipped"
+	case errors.Is(err, ErrSubtitleExists), errors.Is(err, ErrTooManySubtitles):
+		result.Error = err.Error()
+	case err != nil:
+		result.Error = "failed to save video"
+		slog.Error("Failed to import video", "video_id", videoID, "error", err)
+	default:
+		result.Status = status
+		result.Subtitles = len(subtitles)
+	}
+
+	return result
+}
+
+// readArchivedSubtitle reads and validates a subtitle's SRT file from an archive, along with
+// the original upload it was converted from. Archives from before originals were exported
+// get the SRT file as their original
+func readArchivedSubtitle(files map[string]*zip.File, archived ExportedSubtitle, maxSize int) (Subtitle, error) {
+	language, err := normalizeLanguage(archived.Language)
+	if err != nil {
+		return Subtitle{}, err
+	}
+
+	content, err := readArchiveFile(files, archived.File, maxSize)
+	if err != nil {
+		return Subtitle{}, err
+	}
+
+	srt := convertToSRT(content, "srt")
+	if err := validateSRT(srt); err != nil {
+		return Subtitle{}, fmt.Errorf("%s is not a valid subtitle file: %w", archived.File, err)
+	}
+
+	originalType := "srt"
+	if archived.OriginalType != "" {
+		originalType = archived.OriginalType
+		if !subtitleTypes[originalType] {
+			return Subtitle{}, fmt.Errorf("%s has an unsupported original type %q", archived.File, originalType)
+		}
+	}
+	original := content
+	if archived.OriginalFile != "" {
+		original, err = readArchiveFile(files, archived.OriginalFile, maxSize)
+		if err != nil {
+			return Subtitle{}, err
+		}
+	}
+
+	return Subtitle{
+		Language:        language,
+		Type:            "srt",
+		Content:         srt,
+		OriginalType:    originalType,
+		OriginalContent: original,
+	}, nil
+}
+
+// readArchiveFile reads a file of at most maxSize bytes from an archive
+func readArchiveFile(files map[string]*zip.File, name string, maxSize int) (string, error) {
+	zf, ok := files[name]
+	if !ok {
+		return "", fmt.Errorf("%s is missing from the archive", name)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer rc.Close()
+
+	// Don't trust the size in the zip header, a compressed file can expand to anything
+	content, err := io.ReadAll(io.LimitReader(rc, int64(maxSize)+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(content) > maxSize {
+		return "", fmt.Errorf("%s exceeds the maximum size of %d bytes", name, maxSize)
+	}
+	return string(content), nil
+}
+
+// readArchiveJSON decodes a JSON file of at most maxSize bytes from an archive
+func readArchiveJSON(zf *zip.File, v any, maxSize int) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// As with subtitles, the size in the zip header can't be trusted
+	data, err := io.ReadAll(io.LimitReader(rc, int64(maxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSize {
+		return fmt.Errorf("exceeds the maximum size of %d bytes", maxSize)
+	}
+	return json.Unmarshal(data, v)
+}
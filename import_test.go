@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadArchiveJSONLimitsSize(t *testing.T) {
+	// Compresses to a few KB but expands well past the limit
+	manifest := `{"videos": [], "padding": "` + strings.Repeat(" ", 1<<20) + `"}`
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(exportManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(manifest)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded ExportManifest
+	if err := readArchiveJSON(zr.File[0], &decoded, 64*1024); err == nil {
+		t.Error("readArchiveJSON decoded a manifest over the limit")
+	}
+	if err := readArchiveJSON(zr.File[0], &decoded, len(manifest)); err != nil {
+		t.Errorf("readArchiveJSON rejected a manifest within the limit: %v", err)
+	}
+}
+
+func TestExportImportKeepsOriginalUploads(t *testing.T) {
+	source := newTestRepository(t)
+	ctx := context.Background()
+
+	videoID, err := source.CreateVideo(ctx, "dQw4w9WgXcQ", youtubeCanonicalURL("dQw4w9WgXcQ"), "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n"
+	srt := convertToSRT(vtt, "vtt")
+	if _, err := source.CreateSubtitle(ctx, int(videoID), "en", "srt", srt, "vtt", vtt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.CreateSubtitle(ctx, int(videoID), "fr", "srt", srt, "srt", srt); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	w := bufio.NewWriter(&archive)
+	if err := writeExport(ctx, source, w); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestRepository(t)
+	app := newTestApp()
+	app.Post("/import", importLibrary(target, 1<<20))
+
+	body, contentType := multipartBody(t, nil, "export.zip", archive.String())
+	req := httptest.NewRequest("POST", "/import", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary ImportSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Created != 1 {
+		t.Fatalf("import summary is %+v, want 1 video created", summary)
+	}
+
+	video, err := target.GetVideoByURL(ctx, "dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		language     string
+		originalType string
+		original     string
+	}{
+		{"en", "vtt", vtt},
+		{"fr", "srt", srt},
+	}
+	for _, tt := range tests {
+		meta, err := target.GetSubtitleByLanguage(ctx, video.ID, tt.language)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subtitle, err := target.GetSubtitleByID(ctx, meta.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subtitle.Content != srt {
+			t.Errorf("%s content is %q, want %q", tt.language, subtitle.Content, srt)
+		}
+		if subtitle.OriginalType != tt.originalType || subtitle.OriginalContent != tt.original {
+			t.Errorf("%s original is %s %q, want %s %q", tt.language, subtitle.OriginalType, subtitle.OriginalContent, tt.originalType, tt.original)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// languageTagPattern matches a lowercase BCP-47 style tag, e.g. "en", "pt-br" or "zh-hans-cn"
+var languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// iso6391Codes lists the two-letter ISO 639-1 language codes
+var iso6391Codes = toSet(strings.Fields(`
+	aa ab ae af ak am an ar as av ay az ba be bg bh bi bm bn bo br bs ca ce ch co cr cs cu cv cy
+	da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy ga gd gl gn gu gv ha he hi ho hr ht hu
+	hy hz ia id ie ig ii ik io is it iu ja jv ka kg ki kj kk kl km kn ko kr ks ku kv kw ky la lb
+	lg li ln lo lt lu lv mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny oc oj om
+	or os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se sg si sk sl sm sn so sq sr ss st su sv sw
+	ta te tg th ti tk tl tn to tr ts tt tw ty ug uk ur uz ve vi vo wa wo xh yi yo za zh zu
+`))
+
+// languageNames maps common English language names to their codes
+var languageNames = map[string]string{
+	"arabic":     "ar",
+	"bengali":    "bn",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"indonesian": "id",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"malay":      "ms",
+	"norwegian":  "no",
+	"persian":    "fa",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"thai":       "th",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+	"urdu":       "ur",
+	"vietnamese": "vi",
+}
+
+// normalizeLanguage converts a language code or common name to a lowercase
+// BCP-47 tag, e.g. "EN" to "en", "pt_BR" to "pt-br" and "English" to "en"
+func normalizeLanguage(language string) (string, error) {
+	tag := strings.ToLower(strings.TrimSpace(language))
+	tag = strings.ReplaceAll(tag, "_", "-")
+
+	if code, ok := languageNames[tag]; ok {
+		return code, nil
+	}
+
+	if !languageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid language code %q", language)
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	if len(primary) == 2 && !iso6391Codes[primary] {
+		return "", fmt.Errorf("unknown language code %q", language)
+	}
+
+	return tag, nil
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
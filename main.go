@@ -1,39 +1,74 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
 	"embed"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
 )
 
 //go:embed static/*
 var staticFS embed.FS
 
 type Video struct {
-	ID          int    `json:"id" db:"id"`
-	OriginalURL string `json:"original_url" db:"original_url"`
-	Title       string `json:"title" db:"title"`
+	ID          int        `json:"id" db:"id"`
+	VideoID     string     `json:"video_id" db:"video_id"`
+	OriginalURL string     `json:"original_url" db:"original_url"`
+	Title       string     `json:"title" db:"title"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// ThumbnailURL is computed from VideoID when queried, it isn't stored
+	ThumbnailURL string `json:"thumbnail_url" db:"thumbnail_url"`
 }
 
 type Subtitle struct {
-	ID       int    `json:"id" db:"id"`
-	VideoID  int    `json:"video_id" db:"video_id"`
-	Language string `json:"language" db:"language"`
-	Type     string `json:"type" db:"type"`
-	Content  string `json:"content" db:"content"`
+	ID              int       `json:"id" db:"id"`
+	VideoID         int       `json:"video_id" db:"video_id"`
+	Language        string    `json:"language" db:"language"`
+	Type            string    `json:"type" db:"type"`
+	Content         string    `json:"content,omitempty" db:"content"`
+	OriginalType    string    `json:"original_type" db:"original_type"`
+	OriginalContent string    `json:"original_content,omitempty" db:"original_content"`
+	Compressed      bool      `json:"-" db:"compressed"`
+	LineCount       int       `json:"line_count" db:"line_count"`
+	CharCount       int       `json:"char_count" db:"char_count"`
+	CueCount        int       `json:"cue_count" db:"cue_count"`
+	DurationMS      int64     `json:"duration_ms" db:"duration_ms"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+	// Cues replaces Content in public responses when parsed cues are requested
+	Cues []Cue `json:"cues,omitempty" db:"-"`
 }
 
 type VideoResponse struct {
@@ -41,16 +76,138 @@ type VideoResponse struct {
 	Subtitles []Subtitle `json:"subtitles"`
 }
 
+type VideoListResponse struct {
+	Items  []VideoWithSubs `json:"items"`
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+type SubtitleListResponse struct {
+	Items  []Subtitle `json:"items"`
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+}
+
+const (
+	// defaultMaxSubtitleSize is the default limit for a single uploaded subtitle file
+	defaultMaxSubtitleSize = 5 * 1024 * 1024
+	// bulkUploadSizeFactor sets the request body limit as a multiple of the file size limit
+	bulkUploadSizeFactor = 10
+
+	// defaultRateLimit is the number of requests a client may make to public routes per window
+	defaultRateLimit       = 60
+	defaultRateLimitWindow = time.Minute
+
+	// Connection timeouts keep slow or idle clients from holding connections open
+	defaultReadTimeout  = 30 * time.Second
+	defaultWriteTimeout = 60 * time.Second
+	defaultIdleTimeout  = 2 * time.Minute
+	// defaultRequestTimeout bounds how long a handler's database queries and fetches may take
+	defaultRequestTimeout = 30 * time.Second
+)
+
+// ErrorResponse is the JSON body returned for failed API requests
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Status int          `json:"status"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError explains why a request body field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by handlers to reject a request body field by field,
+// so clients can point at the offending inputs. It is answered with a 400
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return "Validation failed"
+}
+
+// maxVideoTitleLength bounds video titles in characters, YouTube's own limit is 100
+const maxVideoTitleLength = 200
+
+// defaultAuthRealm is shown in the browser's login prompt for admin pages
+const defaultAuthRealm = "Subbed Admin"
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
 // customErrorHandler handles all errors in a centralized way
 func customErrorHandler(c *fiber.Ctx, err error) error {
-	if errors.Is(err, fiber.ErrInternalServerError) {
+	// Plain errors (e.g. from the database) become 500s, so log them too
+	var fiberErr *fiber.Error
+	var validationErr *ValidationError
+	clientErr := errors.As(err, &validationErr) ||
+		(errors.As(err, &fiberErr) && fiberErr.Code < fiber.StatusInternalServerError)
+	if !clientErr {
 		slog.Error("Request error",
 			"error", err,
 			"path", c.Path(),
-			"method", c.Method())
+			"method", c.Method(),
+			"request_id", requestID(c))
+	}
+
+	// API clients get JSON, other routes keep fiber's plain text responses
+	if !strings.HasPrefix(c.Path(), "/api/") {
+		return fiber.DefaultErrorHandler(c, err)
+	}
+
+	if validationErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:  validationErr.Error(),
+			Status: fiber.StatusBadRequest,
+			Fields: validationErr.Fields,
+		})
+	}
+
+	// Don't leak internal error details to clients
+	code := fiber.StatusInternalServerError
+	message := utils.StatusMessage(code)
+	if fiberErr != nil {
+		code = fiberErr.Code
+		message = fiberErr.Message
+	}
+
+	return c.Status(code).JSON(ErrorResponse{
+		Error:  message,
+		Status: code,
+	})
+}
+
+// responseStatus returns the status code a request will be answered with. Errors
+// are only turned into responses by the error handler after middleware returns.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
 	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
 
-	return fiber.DefaultErrorHandler(c, err)
+// requestID returns the ID assigned to the request by the requestid middleware
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+	return id
 }
 
 func main() {
@@ -64,18 +221,41 @@ func run() error {
 	// Get debug mode first to configure logging
 	debug := os.Getenv("DEBUG") == "true"
 
+	// DEBUG implies debug logging unless LOG_LEVEL says otherwise
+	logLevel := slog.LevelInfo
+	if debug {
+		logLevel = slog.LevelDebug
+	}
+	if envLevel := os.Getenv("LOG_LEVEL"); envLevel != "" {
+		if err := logLevel.UnmarshalText([]byte(envLevel)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q, expected debug, info, warn or error", envLevel)
+		}
+	}
+
+	// DEBUG implies human-readable text logs unless LOG_FORMAT says otherwise
+	logFormat := "json"
+	if debug {
+		logFormat = "text"
+	}
+	if envFormat := os.Getenv("LOG_FORMAT"); envFormat != "" {
+		logFormat = strings.ToLower(envFormat)
+	}
+
 	// Initialize structured logging
 	var handler slog.Handler
-	if debug {
+	switch logFormat {
+	case "text":
 		// Human-readable text format for development
 		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: logLevel,
 		})
-	} else {
+	case "json":
 		// JSON format for production
 		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelInfo,
+			Level: logLevel,
 		})
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q, expected json or text", logFormat)
 	}
 	slog.SetDefault(slog.New(handler))
 
@@ -86,17 +266,9 @@ func run() error {
 	}
 
 	// Get listen address from environment
-	listenAddr := os.Getenv("LISTEN_ADDR")
-	if listenAddr == "" {
-		host := os.Getenv("HOST")
-		if host == "" {
-			host = "127.0.0.1"
-		}
-		port := os.Getenv("PORT")
-		if port == "" {
-			port = "3000"
-		}
-		listenAddr = host + ":" + port
+	listenAddr, err := listenAddrFromEnvironment()
+	if err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
 	}
 
 	creds, err := newCredentialsFromEnvironment("ADMIN_CREDENTIALS")
@@ -104,19 +276,101 @@ func run() error {
 		return fmt.Errorf("failed to parse admin credentials: %w", err)
 	}
 
+	maxSubtitleSize, err := intFromEnvironment("MAX_SUBTITLE_SIZE", defaultMaxSubtitleSize)
+	if err != nil {
+		return err
+	}
+
+	// Served when neither a requested language nor its base language is available
+	var fallbackLanguage string
+	if value := os.Getenv("FALLBACK_LANGUAGE"); value != "" {
+		fallbackLanguage, err = normalizeLanguage(value)
+		if err != nil {
+			return fmt.Errorf("invalid FALLBACK_LANGUAGE: %w", err)
+		}
+	}
+
+	rateLimit, err := intFromEnvironment("RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		return err
+	}
+	rateLimitWindow, err := durationFromEnvironment("RATE_LIMIT_WINDOW", defaultRateLimitWindow)
+	if err != nil {
+		return err
+	}
+
+	readTimeout, err := durationFromEnvironment("READ_TIMEOUT", defaultReadTimeout)
+	if err != nil {
+		return err
+	}
+	writeTimeout, err := durationFromEnvironment("WRITE_TIMEOUT", defaultWriteTimeout)
+	if err != nil {
+		return err
+	}
+	idleTimeout, err := durationFromEnvironment("IDLE_TIMEOUT", defaultIdleTimeout)
+	if err != nil {
+		return err
+	}
+	requestTimeout, err := durationFromEnvironment("REQUEST_TIMEOUT", defaultRequestTimeout)
+	if err != nil {
+		return err
+	}
+
+	corsOrigins, err := corsOriginsFromEnvironment("CORS_ALLOWED_ORIGINS")
+	if err != nil {
+		return err
+	}
+
+	languagePriority, err := languagePriorityFromEnvironment("LANGUAGE_PRIORITY")
+	if err != nil {
+		return err
+	}
+
+	trustedProxies, err := trustedProxiesFromEnvironment("TRUSTED_PROXIES")
+	if err != nil {
+		return err
+	}
+
+	metricsEnabled := os.Getenv("METRICS_ENABLED") == "true"
+
+	compressionLevel, err := compressionLevelFromEnvironment("COMPRESSION_LEVEL")
+	if err != nil {
+		return err
+	}
+
+	dbConfig, err := databaseConfigFromEnvironment()
+	if err != nil {
+		return err
+	}
+
 	// Initialize repository
-	repo, err := NewRepository(dbPath)
+	repo, err := NewRepository(dbPath, dbConfig)
 	if err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	defer repo.Close()
 
 	// Create Fiber app
-	app := fiber.New(fiber.Config{
+	appConfig := fiber.Config{
 		Immutable:             true,
 		ErrorHandler:          customErrorHandler,
 		DisableStartupMessage: true,
-	})
+		// Leave room for several files in a bulk upload
+		BodyLimit:    maxSubtitleSize * bulkUploadSizeFactor,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	// Behind a reverse proxy, take the client IP used for logging and rate limiting from
+	// X-Forwarded-For, but only when the request comes from one of the trusted proxies
+	if len(trustedProxies) > 0 {
+		appConfig.ProxyHeader = fiber.HeaderXForwardedFor
+		appConfig.EnableTrustedProxyCheck = true
+		appConfig.TrustedProxies = trustedProxies
+		// X-Forwarded-For can hold a chain of addresses, this picks the first valid one
+		appConfig.EnableIPValidation = true
+	}
+	app := fiber.New(appConfig)
 	app.Hooks().OnListen(func(listen fiber.ListenData) error {
 		addr := listen.Host + ":" + listen.Port
 		slog.Info("Listening", "addr", addr)
@@ -128,6 +382,26 @@ func run() error {
 		EnableStackTrace: true,
 	}))
 
+	// Tag each request with an ID (reusing X-Request-ID if the client sent one)
+	// so access logs and errors can be correlated
+	app.Use(requestid.New())
+
+	if metricsEnabled {
+		app.Use(metricsMiddleware())
+	}
+
+	// Compress responses for clients that accept it. Bodies that already have a
+	// Content-Encoding or aren't text-like (e.g. images) are left alone
+	app.Use(compress.New(compress.Config{
+		Level: compressionLevel,
+		// The Prometheus handler compresses its own output, and zip archives are already compressed.
+		// Byte ranges are offsets into the uncompressed body, so partial responses aren't compressed
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/metrics" || c.Path() == "/api/admin/export" || strings.HasSuffix(c.Path(), ".zip") ||
+				c.Get(fiber.HeaderRange) != ""
+		},
+	}))
+
 	// Add custom slog logger middleware
 	app.Use(func(c *fiber.Ctx) error {
 		start := time.Now()
@@ -137,12 +411,13 @@ func run() error {
 
 		// Log after request is complete
 		duration := time.Since(start)
-		status := c.Response().StatusCode()
+		status := responseStatus(c, err)
 
 		logAttrs := []any{
+			"request_id", requestID(c),
 			"method", c.Method(),
 			"status", status,
-			"path", c.Request().URI().RequestURI(),
+			"path", string(c.Request().URI().RequestURI()),
 			"duration", duration.String(),
 			"ip", c.IP(),
 			"user_agent", c.Get("User-Agent"),
@@ -159,47 +434,117 @@ func run() error {
 		return err
 	})
 
+	// Give handlers a context with a deadline so long queries get cancelled
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), requestTimeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "Request timed out")
+		}
+		return err
+	})
+
+	staticRoot, err := staticRootFromEnvironment(debug)
+	if err != nil {
+		return err
+	}
+
+	// Pages and assets are all served by the filesystem middleware, which streams files
+	// and sets their content type and Last-Modified
+	staticFiles := http.FS(staticRoot)
 	serveFile := func(filePath string) fiber.Handler {
 		return func(c *fiber.Ctx) error {
-			if debug {
-				return c.SendFile("./static/" + filePath)
-			}
-			content, err := staticFS.ReadFile("static/" + filePath)
-			if err != nil {
-				return err
-			}
-			c.Set("Content-Type", "text/html")
-			return c.Send(content)
+			return filesystem.SendFile(c, staticFiles, filePath)
 		}
 	}
 
 	// Specific routes (registered first to take precedence)
 
-	if debug {
-		app.Static("/static", "./static")
-	} else {
-		staticFS, err := fs.Sub(staticFS, "static")
-		if err != nil {
-			return fmt.Errorf("failed to load static files: %w", err)
-		}
-		app.Use("/static", filesystem.New(filesystem.Config{
-			Root: http.FS(staticFS),
+	app.Use("/static", filesystem.New(filesystem.Config{
+		Root: staticFiles,
+	}))
+
+	// Allow cross-origin API requests only from configured origins, same-origin otherwise
+	if len(corsOrigins) > 0 {
+		app.Use("/api", cors.New(cors.Config{
+			AllowOrigins:     strings.Join(corsOrigins, ","),
+			AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+			AllowCredentials: false,
 		}))
 	}
 
-	app.Get("/", serveFile("index.html"))
+	// Rate limit public routes per client IP, admin routes are exempt
+	publicLimiter := limiter.New(limiter.Config{
+		Max:        rateLimit,
+		Expiration: rateLimitWindow,
+	})
+
+	app.Get("/", publicLimiter, serveFile("index.html"))
+
+	app.Get("/health", handleHealth(repo))
+	// Without a configured base URL the sitemap would have to trust the request's Host header
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		slog.Warn("BASE_URL is not set, /sitemap.xml is disabled")
+	}
+	app.Get("/sitemap.xml", publicLimiter, sitemap(repo, baseURL))
 
-	app.Get("/api/video", handleVideoRequest(repo))
+	if metricsEnabled {
+		app.Get("/metrics", metricsHandler(os.Getenv("METRICS_TOKEN")))
+	}
 
-	auth := basicAuthMiddleware(creds)
+	app.Get("/api/video", publicLimiter, handleVideoRequest(repo, languagePriority))
+	app.Get("/api/validate-url", publicLimiter, validateURL(repo))
+	app.Get("/api/subtitles/:id/download", publicLimiter, downloadSubtitle(repo))
+	app.Get("/api/subtitles/:id/cues", publicLimiter, subtitleCues(repo))
+	app.Get("/api/video/subtitle", publicLimiter, videoSubtitle(repo, fallbackLanguage))
+	app.Get("/api/video/subtitles", publicLimiter, videoSubtitles(repo))
+	app.Get("/api/video/:videoID/subtitles/:lang.vtt", publicLimiter, subtitleTrack(repo, fallbackLanguage))
+	// Not rate limited so list pages can load many thumbnails, they're cached on both ends
+	thumbnails := newThumbnailCache(thumbnailCacheSize, thumbnailCacheTTL, thumbnailFailureCacheTTL)
+	app.Get("/api/video/:videoID/thumbnail.jpg", videoThumbnail(repo, thumbnails))
+
+	authRealm := os.Getenv("AUTH_REALM")
+	if authRealm == "" {
+		authRealm = defaultAuthRealm
+	}
+	auth := adminAuthMiddleware(creds, os.Getenv("API_KEY"), authRealm)
 	app.Get("/admin", auth, serveFile("admin.html"))
 
 	adminAPI := app.Group("/api/admin", auth)
 	adminAPI.Get("/videos", listVideos(repo))
 	adminAPI.Post("/videos", addVideo(repo))
+	adminAPI.Post("/videos/import", importVideo(repo, maxSubtitleSize))
+	adminAPI.Post("/videos/delete-bulk", deleteVideosBulk(repo))
+	adminAPI.Get("/videos/deleted", listDeletedVideos(repo))
+	adminAPI.Get("/videos/by-youtube-id/:id", getVideoByYouTubeID(repo, languagePriority))
+	adminAPI.Get("/videos/:id", getVideo(repo, languagePriority))
+	adminAPI.Get("/videos/:id/subtitles", listVideoSubtitles(repo))
+	adminAPI.Get("/videos/:id/subtitles.zip", exportVideoSubtitles(repo))
+	adminAPI.Put("/videos/:id", updateVideo(repo))
 	adminAPI.Delete("/videos/:id", deleteVideo(repo))
-	adminAPI.Post("/subtitles", uploadSubtitle(repo))
+	adminAPI.Post("/videos/:id/restore", restoreVideo(repo))
+	adminAPI.Post("/videos/:id/merge", mergeVideos(repo))
+	adminAPI.Post("/videos/:id/refresh-title", refreshVideoTitle(repo))
+	adminAPI.Post("/videos/:id/import-captions", importCaptions(repo, maxSubtitleSize))
+	adminAPI.Post("/subtitles", uploadSubtitle(repo, maxSubtitleSize))
+	adminAPI.Post("/subtitles/bulk", uploadSubtitlesBulk(repo, maxSubtitleSize))
+	adminAPI.Post("/subtitles/preview", previewSubtitle(maxSubtitleSize))
+	adminAPI.Put("/subtitles/:id", updateSubtitle(repo))
+	adminAPI.Patch("/subtitles/:id", renameSubtitle(repo))
+	adminAPI.Post("/subtitles/:id/normalize", normalizeSubtitle(repo))
+	adminAPI.Post("/subtitles/:id/shift", shiftSubtitle(repo))
+	adminAPI.Post("/subtitles/:id/reprocess", reprocessSubtitle(repo))
+	adminAPI.Post("/subtitles/:id/split", splitSubtitle(repo))
 	adminAPI.Delete("/subtitles/:id", deleteSubtitle(repo))
+	adminAPI.Get("/languages", listLanguages(repo))
+	adminAPI.Get("/stats", libraryStats(repo))
+	adminAPI.Post("/maintenance", runMaintenance(repo))
+	adminAPI.Get("/export", exportLibrary(repo))
+	adminAPI.Post("/import", importLibrary(repo, maxSubtitleSize))
 
 	app.Get("/*", func(c *fiber.Ctx) error {
 		_, ok := youtubeURLFromPath(string(c.Request().URI().PathOriginal()))
@@ -209,23 +554,38 @@ func run() error {
 		return serveFile("index.html")(c)
 	})
 
-	if debug {
-		app.Static("/", "./static")
-	} else {
-		staticFS, err := fs.Sub(staticFS, "static")
+	app.Use("/", filesystem.New(filesystem.Config{
+		Root: staticFiles,
+	}))
+
+	// Shut down gracefully on SIGINT/SIGTERM so in-flight requests finish
+	// and the database is closed cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(listenAddr)
+	}()
+
+	select {
+	case err := <-listenErr:
 		if err != nil {
-			slog.Error("Failed to load static files", "error", err)
-			os.Exit(1)
+			return fmt.Errorf("server failed to start: %w", err)
 		}
-		app.Use("/", filesystem.New(filesystem.Config{
-			Root: http.FS(staticFS),
-		}))
+		return nil
+	case <-ctx.Done():
 	}
 
-	if err := app.Listen(listenAddr); err != nil {
-		return fmt.Errorf("server failed to start: %w", err)
+	slog.Info("Shutting down", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
 	}
 
+	slog.Info("Server stopped")
 	return nil
 }
 
@@ -246,12 +606,215 @@ func newCredentialsFromEnvironment(envVar string) (Credentials, error) {
 	}, nil
 }
 
-func basicAuthMiddleware(creds Credentials) fiber.Handler {
-	return basicauth.New(basicauth.Config{
+// intFromEnvironment reads a positive integer from an environment variable, or returns defaultValue if unset
+func intFromEnvironment(envVar string, defaultValue int) (int, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive integer", envVar, value)
+	}
+	return n, nil
+}
+
+// databaseConfigFromEnvironment reads SQLite tuning and storage overrides, falling back to DefaultDatabaseConfig
+func databaseConfigFromEnvironment() (DatabaseConfig, error) {
+	cacheSizeKB, err := intFromEnvironment("SQLITE_CACHE_SIZE_KB", DefaultDatabaseConfig.CacheSizeKB)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	mmapSize, err := intFromEnvironment("SQLITE_MMAP_SIZE", DefaultDatabaseConfig.MmapSize)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	busyTimeout, err := durationFromEnvironment("SQLITE_BUSY_TIMEOUT", DefaultDatabaseConfig.BusyTimeout)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	maxOpenConns, err := intFromEnvironment("SQLITE_MAX_OPEN_CONNS", DefaultDatabaseConfig.MaxOpenConns)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	busyAttempts, err := intFromEnvironment("SQLITE_BUSY_ATTEMPTS", DefaultDatabaseConfig.BusyAttempts)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+	maxSubtitlesPerVideo, err := intFromEnvironment("MAX_SUBTITLES_PER_VIDEO", DefaultDatabaseConfig.MaxSubtitlesPerVideo)
+	if err != nil {
+		return DatabaseConfig{}, err
+	}
+
+	return DatabaseConfig{
+		CacheSizeKB:  cacheSizeKB,
+		MmapSize:     mmapSize,
+		BusyTimeout:  busyTimeout,
+		MaxOpenConns: maxOpenConns,
+		BusyAttempts: busyAttempts,
+		// Only affects writes, existing rows keep whatever encoding they were stored with
+		CompressSubtitles:    os.Getenv("COMPRESS_SUBTITLES") == "true",
+		MaxSubtitlesPerVideo: maxSubtitlesPerVideo,
+	}, nil
+}
+
+// durationFromEnvironment reads a positive duration (e.g. "30s", "1m") from an environment variable,
+// or returns defaultValue if unset
+func durationFromEnvironment(envVar string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive duration like 30s or 1m", envVar, value)
+	}
+	return d, nil
+}
+
+// corsOriginsFromEnvironment reads a comma-separated list of allowed origins, e.g.
+// "https://example.com,https://player.example.com". Empty means same-origin only.
+func corsOriginsFromEnvironment(envVar string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv(envVar), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("invalid origin %q in %s, expected scheme://host[:port]", origin, envVar)
+			}
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// languagePriorityFromEnvironment reads a comma-separated list of languages to list a
+// video's subtitles in, e.g. "en,es,pt-br"
+func languagePriorityFromEnvironment(envVar string) ([]string, error) {
+	var languages []string
+	for _, value := range strings.Split(os.Getenv(envVar), ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		language, err := normalizeLanguage(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid language %q in %s: %w", value, envVar, err)
+		}
+		languages = append(languages, language)
+	}
+	return languages, nil
+}
+
+// trustedProxiesFromEnvironment reads a comma-separated list of proxy IPs or CIDR ranges
+// whose X-Forwarded-For header is trusted, e.g. "10.0.0.1,172.16.0.0/12"
+func trustedProxiesFromEnvironment(envVar string) ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv(envVar), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid proxy %q in %s, expected an IP address or CIDR range", proxy, envVar)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// compressionLevelFromEnvironment reads a response compression level, one of
+// "disabled", "speed", "default" or "best", defaulting to "default"
+func compressionLevelFromEnvironment(envVar string) (compress.Level, error) {
+	switch value := strings.ToLower(os.Getenv(envVar)); value {
+	case "", "default":
+		return compress.LevelDefault, nil
+	case "disabled":
+		return compress.LevelDisabled, nil
+	case "speed":
+		return compress.LevelBestSpeed, nil
+	case "best":
+		return compress.LevelBestCompression, nil
+	default:
+		return 0, fmt.Errorf("invalid %s %q, expected disabled, speed, default or best", envVar, value)
+	}
+}
+
+// staticRootFromEnvironment picks where static files are served from: STATIC_DIR if set,
+// ./static in debug mode so edits show up without rebuilding, or the embedded files
+func staticRootFromEnvironment(debug bool) (fs.FS, error) {
+	dir := os.Getenv("STATIC_DIR")
+	if dir == "" && debug {
+		dir = "./static"
+	}
+	if dir == "" {
+		return fs.Sub(staticFS, "static")
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATIC_DIR: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("invalid STATIC_DIR %q, expected a directory", dir)
+	}
+	return os.DirFS(dir), nil
+}
+
+// listenAddrFromEnvironment builds the listen address from LISTEN_ADDR, or HOST and PORT
+func listenAddrFromEnvironment() (string, error) {
+	if listenAddr := os.Getenv("LISTEN_ADDR"); listenAddr != "" {
+		return listenAddr, nil
+	}
+
+	host := os.Getenv("HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	portInt, err := strconv.Atoi(port)
+	if err != nil || portInt < 1 || portInt > 65535 {
+		return "", fmt.Errorf("invalid PORT %q, expected an integer between 1 and 65535", port)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// adminAuthMiddleware accepts either basic auth or, if apiKey is set, a matching X-API-Key header.
+// Unauthenticated API requests get a JSON 401 without a challenge, so browsers don't prompt for
+// credentials on background requests, while admin pages still trigger the login dialog for realm
+func adminAuthMiddleware(creds Credentials, apiKey, realm string) fiber.Handler {
+	basicAuth := basicauth.New(basicauth.Config{
 		Users: map[string]string{
 			creds.Username: creds.Password,
 		},
+		Realm: realm,
+		Unauthorized: func(c *fiber.Ctx) error {
+			if strings.HasPrefix(c.Path(), "/api/") {
+				return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, "Basic realm="+strconv.Quote(realm))
+			return c.SendStatus(fiber.StatusUnauthorized)
+		},
 	})
+
+	return func(c *fiber.Ctx) error {
+		if apiKey != "" {
+			if key := c.Get("X-API-Key"); key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				return c.Next()
+			}
+		}
+		return basicAuth(c)
+	}
 }
 
 func youtubeURLFromPath(path string) (string, bool) {
@@ -270,31 +833,110 @@ func youtubeURLFromPath(path string) (string, bool) {
 	return urlStr, true
 }
 
+// youtubeHosts are the hosts that serve YouTube watch, shorts, embed and live URLs
+var youtubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"music.youtube.com":        true,
+	"youtube-nocookie.com":     true,
+	"www.youtube-nocookie.com": true,
+}
+
+// youtubeVideoIDPattern matches YouTube's 11 character video IDs
+var youtubeVideoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
 func youtubeVideoIDFromURL(urlStr string) (string, bool) {
 	parsedURL, err := url.Parse(urlStr)
-	if err == nil {
-		// Extract video ID from different YouTube URL formats
-		if strings.Contains(parsedURL.Host, "youtube.com") {
-			// Standard format: youtube.com/watch?v=VIDEO_ID
-			videoID := parsedURL.Query().Get("v")
-			if videoID != "" {
-				return videoID, true
-			}
-		} else if strings.Contains(parsedURL.Host, "youtu.be") {
-			// Short format: youtu.be/VIDEO_ID
-			videoID := strings.TrimPrefix(parsedURL.Path, "/")
-			if videoID != "" {
-				return videoID, true
+	if err != nil {
+		return "", false
+	}
+
+	var videoID string
+	host := strings.ToLower(parsedURL.Hostname())
+	switch {
+	case youtubeHosts[host]:
+		// Standard format: youtube.com/watch?v=VIDEO_ID
+		if parsedURL.Path == "/watch" {
+			videoID = parsedURL.Query().Get("v")
+			break
+		}
+
+		// Path formats: youtube.com/shorts/VIDEO_ID, /embed/VIDEO_ID, /live/VIDEO_ID
+		segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+		if len(segments) == 2 {
+			switch segments[0] {
+			case "shorts", "embed", "live":
+				videoID = segments[1]
 			}
 		}
+	case host == "youtu.be" || host == "www.youtu.be":
+		// Short format: youtu.be/VIDEO_ID
+		videoID = strings.Trim(parsedURL.Path, "/")
+	}
+
+	if !youtubeVideoIDPattern.MatchString(videoID) {
+		return "", false
+	}
+	return videoID, true
+}
+
+// youtubeCanonicalURL returns the standard watch URL for a video ID
+func youtubeCanonicalURL(videoID string) string {
+	return "https://www.youtube.com/watch?v=" + url.QueryEscape(videoID)
+}
+
+func handleHealth(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := repo.Ping(c.UserContext()); err != nil {
+			slog.Error("Health check failed", "error", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "unavailable"})
+		}
+		return c.JSON(fiber.Map{"status": "ok"})
+	}
+}
+
+// validateURL checks a pasted YouTube URL without storing anything, so forms can give
+// immediate feedback. Invalid URLs are a normal result rather than an error
+func validateURL(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		videoID, ok := youtubeVideoIDFromURL(strings.TrimSpace(c.Query("url")))
+		if !ok {
+			return c.JSON(fiber.Map{"valid": false})
+		}
+
+		exists := true
+		_, err := repo.GetVideoByURL(c.UserContext(), videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			exists = false
+		} else if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"valid":         true,
+			"video_id":      videoID,
+			"canonical_url": youtubeCanonicalURL(videoID),
+			"exists":        exists,
+		})
 	}
+}
 
-	return "", false
+// subtitleOrder picks the order of a video's subtitles in a response: languagePriority
+// first and the rest alphabetically, or insertion order with ?order=raw
+func subtitleOrder(c *fiber.Ctx, languagePriority []string) []string {
+	if c.Query("order") == "raw" {
+		return nil
+	}
+	if languagePriority == nil {
+		return []string{}
+	}
+	return languagePriority
 }
 
-func handleVideoRequest(repo *Repository) fiber.Handler {
+func handleVideoRequest(repo *Repository, languagePriority []string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 
 		// Get the full path after /v/
 		youtubeURL := c.Query("url")
@@ -307,132 +949,1633 @@ func handleVideoRequest(repo *Repository) fiber.Handler {
 
 		// Look up video in database
 		video, err := repo.GetVideoByURL(ctx, videoID)
-		if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
 			return fiber.NewError(fiber.StatusNotFound, "Video not found")
 		}
+		if err != nil {
+			return err
+		}
 
 		// Get subtitles for this video
-		subtitles, err := repo.GetSubtitlesByVideoID(ctx, video.ID)
+		subtitles, err := repo.GetSubtitlesByVideoID(ctx, video.ID, subtitleOrder(c, languagePriority))
 		if err != nil {
 			return err
 		}
 
+		// With ?cues=true, players get parsed cues instead of raw SRT. Subtitles that
+		// don't parse keep their content so clients can still fall back to it
+		if c.QueryBool("cues") {
+			for i := range subtitles {
+				cues, err := parseSRT(subtitles[i].Content)
+				if err != nil {
+					slog.Warn("Stored subtitle is not valid SRT", "subtitle_id", subtitles[i].ID, "error", err)
+					continue
+				}
+				subtitles[i].Cues = cues
+				subtitles[i].Content = ""
+			}
+		}
+
 		// Return response
 		return c.JSON(VideoResponse{
 			Video: Video{
-				ID:          video.ID,
-				OriginalURL: videoID,
-				Title:       video.Title,
+				ID:           video.ID,
+				VideoID:      video.VideoID,
+				OriginalURL:  videoID,
+				Title:        video.Title,
+				CreatedAt:    video.CreatedAt,
+				UpdatedAt:    video.UpdatedAt,
+				ThumbnailURL: video.ThumbnailURL,
 			},
 			Subtitles: subtitles,
 		})
 	}
 }
 
-func listVideos(repo *Repository) fiber.Handler {
+func downloadSubtitle(repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 
-		videos, err := repo.ListAllVideos(ctx)
-		if err != nil {
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		format := c.Query("format", "srt")
+		if format != "srt" && format != "vtt" && format != "original" {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid format, expected srt, vtt or original")
+		}
+
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		video, err := repo.GetVideoByID(ctx, subtitle.VideoID)
+		if err != nil {
+			return err
+		}
+
+		content := subtitle.Content
+		switch format {
+		case "vtt":
+			content = srtToVTT(content)
+		case "original":
+			content = subtitle.OriginalContent
+			format = subtitle.OriginalType
+		}
+
+		c.Attachment(subtitleFilename(video.Title, subtitle.Language, format))
+		if setSubtitleCacheHeaders(c, format+"\n"+content, subtitle.UpdatedAt) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return sendByteRange(c, content)
+	}
+}
+
+// sendByteRange sends body, or the single byte range asked for in the Range header as a
+// 206 Partial Content so interrupted downloads can resume. Multiple ranges, and ranges
+// whose If-Range doesn't match the current ETag or Last-Modified, get the whole body.
+// Call it after the cache headers are set
+func sendByteRange(c *fiber.Ctx, body string) error {
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if rangeHeader == "" || strings.Contains(rangeHeader, ",") {
+		return c.SendString(body)
+	}
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" &&
+		ifRange != c.GetRespHeader(fiber.HeaderETag) && ifRange != c.GetRespHeader(fiber.HeaderLastModified) {
+		return c.SendString(body)
+	}
+
+	start, end, err := fasthttp.ParseByteRange([]byte(rangeHeader), len(body))
+	if err != nil {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(body)))
+		return fiber.NewError(fiber.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable")
+	}
+
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(body)))
+	return c.Status(fiber.StatusPartialContent).SendString(body[start : end+1])
+}
+
+// videoSubtitles lists a video's subtitles without content, for building a language menu
+func videoSubtitles(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		videoID, ok := youtubeVideoIDFromURL(c.Query("url"))
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid YouTube URL")
+		}
+
+		video, err := repo.GetVideoByURL(ctx, videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		subtitles, err := repo.ListSubtitleMetadata(ctx, video.ID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(subtitles)
+	}
+}
+
+// subtitleForLanguage finds a video's subtitle in language. With ?fallback=true, a missing
+// regional variant falls back to its base language (e.g. "pt-br" to "pt") and then to
+// fallbackLanguage, if set. Content-Language tells the client which language was served
+func subtitleForLanguage(c *fiber.Ctx, repo *Repository, videoID int, language, fallbackLanguage string) (*Subtitle, error) {
+	candidates := []string{language}
+	if c.QueryBool("fallback") {
+		if base, _, ok := strings.Cut(language, "-"); ok {
+			candidates = append(candidates, base)
+		}
+		if fallbackLanguage != "" {
+			candidates = append(candidates, fallbackLanguage)
+		}
+	}
+
+	for _, candidate := range candidates {
+		subtitle, err := repo.GetSubtitleByLanguage(c.UserContext(), videoID, candidate)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(fiber.HeaderContentLanguage, subtitle.Language)
+		return subtitle, nil
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func videoSubtitle(repo *Repository, fallbackLanguage string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		videoID, ok := youtubeVideoIDFromURL(c.Query("url"))
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid YouTube URL")
+		}
+
+		language, err := normalizeLanguage(c.Query("lang"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		format := c.Query("format", "srt")
+		if format != "srt" && format != "vtt" && format != "json" {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid format, expected srt, vtt or json")
+		}
+
+		video, err := repo.GetVideoByURL(ctx, videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		subtitle, err := subtitleForLanguage(c, repo, video.ID, language, fallbackLanguage)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "No subtitle available in this language")
+		}
+		if err != nil {
+			return err
+		}
+
+		content := subtitle.Content
+		switch format {
+		case "srt":
+			c.Set(fiber.HeaderContentType, "application/x-subrip; charset=utf-8")
+		case "vtt":
+			content = srtToVTT(content)
+			c.Set(fiber.HeaderContentType, "text/vtt; charset=utf-8")
+		}
+		if setSubtitleCacheHeaders(c, format+"\n"+content, subtitle.UpdatedAt) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		if format == "json" {
+			return c.JSON(subtitle)
+		}
+		return c.SendString(content)
+	}
+}
+
+// setSubtitleCacheHeaders sets validators for a subtitle response derived from body, and
+// reports whether the client's cached copy is still fresh
+func setSubtitleCacheHeaders(c *fiber.Ctx, body string, updatedAt time.Time) bool {
+	hash := sha256.Sum256([]byte(body + "\n" + updatedAt.UTC().Format(time.RFC3339Nano)))
+
+	// Subtitles can be edited at any time, so caches must revalidate before reuse
+	c.Set(fiber.HeaderCacheControl, "public, no-cache")
+	c.Set(fiber.HeaderETag, `"`+hex.EncodeToString(hash[:16])+`"`)
+	c.Set(fiber.HeaderLastModified, updatedAt.UTC().Format(http.TimeFormat))
+
+	return c.Fresh()
+}
+
+// subtitleTrack serves a video's subtitle as WebVTT, for use as a <track> source
+func subtitleTrack(repo *Repository, fallbackLanguage string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		language, err := normalizeLanguage(c.Params("lang"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		video, err := repo.GetVideoByURL(ctx, c.Params("videoID"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		subtitle, err := subtitleForLanguage(c, repo, video.ID, language, fallbackLanguage)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		content := srtToVTT(subtitle.Content)
+		c.Set(fiber.HeaderContentType, "text/vtt; charset=utf-8")
+		if setSubtitleCacheHeaders(c, "vtt\n"+content, subtitle.UpdatedAt) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+		return c.SendString(content)
+	}
+}
+
+func subtitleCues(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		if setSubtitleCacheHeaders(c, "cues\n"+subtitle.Content, subtitle.UpdatedAt) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+
+		cues, err := parseSRT(subtitle.Content)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Stored subtitle is not valid SRT: "+err.Error())
+		}
+
+		return c.JSON(cues)
+	}
+}
+
+// pageFromQuery reads the limit and offset query parameters, capping limit at maxPageSize
+func pageFromQuery(c *fiber.Ctx) (limit, offset int, err error) {
+	limit = c.QueryInt("limit", defaultPageSize)
+	offset = c.QueryInt("offset", 0)
+	if limit < 0 || offset < 0 {
+		return 0, 0, fiber.NewError(fiber.StatusBadRequest, "limit and offset must not be negative")
+	}
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	return min(limit, maxPageSize), offset, nil
+}
+
+func listVideos(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		limit, offset, err := pageFromQuery(c)
+		if err != nil {
+			return err
+		}
+
+		filter := VideoFilter{Query: strings.TrimSpace(c.Query("q"))}
+		if value := c.Query("has_subtitles"); value != "" {
+			hasSubtitles, err := strconv.ParseBool(value)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid has_subtitles, expected true or false")
+			}
+			filter.HasSubtitles = &hasSubtitles
+		}
+
+		videos, total, err := repo.ListVideosPaged(ctx, filter, limit, offset)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(VideoListResponse{
+			Items:  videos,
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		})
+	}
+}
+
+// listVideoSubtitles pages through a video's subtitles, optionally filtered by language and type
+func listVideoSubtitles(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		idInt, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		limit, offset, err := pageFromQuery(c)
+		if err != nil {
+			return err
+		}
+
+		filter := SubtitleFilter{
+			Type: strings.ToLower(strings.TrimSpace(c.Query("type"))),
+		}
+		if language := c.Query("language"); language != "" {
+			filter.Language, err = normalizeLanguage(language)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		}
+
+		if _, err := repo.GetVideoByID(ctx, idInt); errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		} else if err != nil {
+			return err
+		}
+
+		subtitles, total, err := repo.ListSubtitlesPaged(ctx, idInt, filter, limit, offset)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(SubtitleListResponse{
+			Items:  subtitles,
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		})
+	}
+}
+
+func addVideo(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		var req struct {
+			URL   string `json:"url"`
+			Title string `json:"title"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				return &ValidationError{Fields: []FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}}
+			}
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+
+		var fields []FieldError
+
+		// Store a canonical URL so lookups by video ID are deterministic
+		rawURL := strings.TrimSpace(req.URL)
+		videoID, ok := youtubeVideoIDFromURL(rawURL)
+		if rawURL == "" {
+			fields = append(fields, FieldError{Field: "url", Message: "is required"})
+		} else if !ok {
+			fields = append(fields, FieldError{Field: "url", Message: "must be a YouTube video URL"})
+		}
+
+		title := strings.TrimSpace(req.Title)
+		if utf8.RuneCountInString(title) > maxVideoTitleLength {
+			fields = append(fields, FieldError{Field: "title", Message: fmt.Sprintf("must be at most %d characters", maxVideoTitleLength)})
+		}
+
+		if len(fields) > 0 {
+			return &ValidationError{Fields: fields}
+		}
+
+		// Without a title, use the one on YouTube, or the video ID if YouTube can't be reached
+		if title == "" {
+			fetched, err := fetchYouTubeTitle(ctx, videoID)
+			if err != nil {
+				slog.Warn("Failed to fetch video title", "request_id", requestID(c), "video_id", videoID, "error", err)
+				fetched = videoID
+			}
+			title = fetched
+		}
+
+		// With ?upsert=true, adding an existing video updates its title instead of failing
+		var id int64
+		var err error
+		if c.QueryBool("upsert") {
+			id, err = repo.UpsertVideo(ctx, videoID, youtubeCanonicalURL(videoID), title)
+		} else {
+			id, err = repo.CreateVideo(ctx, videoID, youtubeCanonicalURL(videoID), title)
+		}
+		if errors.Is(err, ErrVideoExists) {
+			return fiber.NewError(fiber.StatusConflict, "A video with this URL already exists, use ?upsert=true to update it")
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"id": id})
+	}
+}
+
+// ImportVideoRequest describes a video to create along with its subtitles
+type ImportVideoRequest struct {
+	URL       string `json:"url"`
+	Title     string `json:"title"`
+	Subtitles []struct {
+		Language string `json:"language"`
+		Type     string `json:"type"`
+		Content  string `json:"content"`
+	} `json:"subtitles"`
+}
+
+// ImportVideoResponse is the created video and the IDs of its subtitles, in request order
+type ImportVideoResponse struct {
+	Video
+	SubtitleIDs []int64 `json:"subtitle_ids"`
+}
+
+func importVideo(repo *Repository, maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		var req ImportVideoRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+
+		videoID, ok := youtubeVideoIDFromURL(strings.TrimSpace(req.URL))
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid YouTube URL")
+		}
+
+		// Validate and convert every subtitle before touching the database
+		subtitles := make([]Subtitle, len(req.Subtitles))
+		for i, sub := range req.Subtitles {
+			if len(sub.Content) > maxSize {
+				return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("subtitles[%d]: content exceeds the maximum size of %d bytes", i, maxSize))
+			}
+			language, err := normalizeLanguage(sub.Language)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("subtitles[%d]: %s", i, err))
+			}
+			fileType, ok := resolveSubtitleType(sub.Type, sub.Content)
+			if !ok {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("subtitles[%d]: unsupported subtitle type: %s", i, fileType))
+			}
+			srt := convertToSRT(sub.Content, fileType)
+			if err := validateSRT(srt); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("subtitles[%d]: invalid subtitle file: %s", i, err))
+			}
+
+			subtitles[i] = Subtitle{
+				Language:        language,
+				Type:            "srt",
+				Content:         srt,
+				OriginalType:    fileType,
+				OriginalContent: sub.Content,
+			}
+		}
+
+		resp := ImportVideoResponse{SubtitleIDs: make([]int64, len(subtitles))}
+		err := repo.WithTx(ctx, func(tx *Repository) error {
+			id, err := tx.CreateVideo(ctx, videoID, youtubeCanonicalURL(videoID), req.Title)
+			if err != nil {
+				return err
+			}
+
+			for i, sub := range subtitles {
+				resp.SubtitleIDs[i], err = tx.CreateSubtitle(ctx, int(id), sub.Language, sub.Type, sub.Content, sub.OriginalType, sub.OriginalContent)
+				if err != nil {
+					return err
+				}
+			}
+
+			video, err := tx.GetVideoByID(ctx, int(id))
+			if err != nil {
+				return err
+			}
+			resp.Video = *video
+			return nil
+		})
+		if errors.Is(err, ErrVideoExists) {
+			return fiber.NewError(fiber.StatusConflict, "A video with this URL already exists")
+		}
+		if errors.Is(err, ErrSubtitleExists) {
+			return fiber.NewError(fiber.StatusConflict, "Subtitles must not repeat a language")
+		}
+		if errors.Is(err, ErrTooManySubtitles) {
+			return subtitleLimitError(repo)
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	}
+}
+
+func getVideo(repo *Repository, languagePriority []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		video, err := repo.GetVideoByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		// Includes subtitle content for editing
+		subtitles, err := repo.GetSubtitlesByVideoID(ctx, video.ID, subtitleOrder(c, languagePriority))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(VideoResponse{
+			Video:     *video,
+			Subtitles: subtitles,
+		})
+	}
+}
+
+func getVideoByYouTubeID(repo *Repository, languagePriority []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		videoID := strings.TrimSpace(c.Params("id"))
+		if videoID == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid YouTube video ID")
+		}
+
+		video, err := repo.GetVideoByURL(ctx, videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		subtitles, err := repo.GetSubtitlesByVideoID(ctx, video.ID, subtitleOrder(c, languagePriority))
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(VideoResponse{
+			Video:     *video,
+			Subtitles: subtitles,
+		})
+	}
+}
+
+func updateVideo(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		var req struct {
+			Title string `json:"title"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+
+		title := strings.TrimSpace(req.Title)
+		if title == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Title is required")
+		}
+
+		err = repo.UpdateVideo(ctx, idInt, title)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		video, err := repo.GetVideoByID(ctx, idInt)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(video)
+	}
+}
+
+// refreshVideoTitle replaces a video's title with its current title on YouTube.
+// The stored title is left alone if YouTube can't be reached
+func refreshVideoTitle(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		idInt, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		video, err := repo.GetVideoByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		title, err := fetchYouTubeTitle(ctx, video.VideoID)
+		if errors.Is(err, errVideoUnavailable) {
+			return fiber.NewError(fiber.StatusNotFound, "Video is unavailable on YouTube")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadGateway, "Failed to fetch title from YouTube: "+err.Error())
+		}
+
+		err = repo.UpdateVideo(ctx, video.ID, title)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		video, err = repo.GetVideoByID(ctx, video.ID)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(video)
+	}
+}
+
+func listLanguages(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		languages, err := repo.CountSubtitlesByLanguage(c.UserContext())
+		if err != nil {
+			return err
+		}
+		return c.JSON(languages)
+	}
+}
+
+func runMaintenance(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result, err := repo.Maintain(c.UserContext(), c.QueryBool("analyze"))
+		if errors.Is(err, ErrMaintenanceRunning) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Ran database maintenance", "reclaimed_bytes", result.ReclaimedBytes,
+			"checkpoint_busy", result.CheckpointBusy, "analyzed", result.Analyzed)
+		return c.JSON(result)
+	}
+}
+
+func libraryStats(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		stats, err := repo.Stats(ctx)
+		if err != nil {
+			return err
+		}
+
+		// With ?details=true, also report database file sizes and table row counts
+		if c.QueryBool("details") {
+			stats.Database, err = repo.DatabaseStats(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return c.JSON(stats)
+	}
+}
+
+func listDeletedVideos(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		videos, err := repo.ListDeletedVideos(c.UserContext())
+		if err != nil {
+			return err
+		}
+		return c.JSON(videos)
+	}
+}
+
+func deleteVideo(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		// Videos are soft-deleted unless a permanent removal is requested
+		if c.QueryBool("permanent") {
+			err = repo.PurgeVideo(ctx, idInt)
+		} else {
+			err = repo.DeleteVideo(ctx, idInt)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// maxBulkDeleteIDs bounds how many videos a single bulk delete may name
+const maxBulkDeleteIDs = 1000
+
+// deleteVideosBulk deletes several videos at once, soft-deleting them unless
+// ?permanent=true is given like for single deletes
+func deleteVideosBulk(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		var req struct {
+			IDs []int `json:"ids"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+		if len(req.IDs) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "ids is required")
+		}
+		if len(req.IDs) > maxBulkDeleteIDs {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("At most %d ids can be deleted at once", maxBulkDeleteIDs))
+		}
+
+		var deleted int64
+		var err error
+		if c.QueryBool("permanent") {
+			deleted, err = repo.PurgeVideos(ctx, req.IDs)
+		} else {
+			deleted, err = repo.DeleteVideos(ctx, req.IDs)
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"deleted": deleted})
+	}
+}
+
+// MergeVideosResponse is the consolidated video after a merge, along with the source
+// subtitles that conflicted with its own and stayed on the deleted source video
+type MergeVideosResponse struct {
+	Video     Video      `json:"video"`
+	Subtitles []Subtitle `json:"subtitles"`
+	Skipped   []Subtitle `json:"skipped"`
+}
+
+// mergeVideos moves another video's subtitles to this one and soft-deletes the other,
+// for when the same video was added twice
+func mergeVideos(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		idInt, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		var req struct {
+			From int `json:"from"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+		if req.From == idInt {
+			return fiber.NewError(fiber.StatusBadRequest, "Cannot merge a video into itself")
+		}
+
+		var response MergeVideosResponse
+		err = repo.WithTx(ctx, func(tx *Repository) error {
+			video, err := tx.GetVideoByID(ctx, idInt)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fiber.NewError(fiber.StatusNotFound, "Video not found")
+			}
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.GetVideoByID(ctx, req.From); errors.Is(err, sql.ErrNoRows) {
+				return fiber.NewError(fiber.StatusNotFound, "Source video not found")
+			} else if err != nil {
+				return err
+			}
+
+			sourceSubtitles, err := tx.ListSubtitleMetadata(ctx, req.From)
+			if err != nil {
+				return err
+			}
+
+			// Subtitles the target already has in the same language and type, or has no room for,
+			// are left behind, so they can still be recovered by restoring the source video
+			response.Skipped = []Subtitle{}
+			for _, subtitle := range sourceSubtitles {
+				err := tx.MoveSubtitle(ctx, subtitle.ID, video.ID)
+				if errors.Is(err, ErrSubtitleExists) || errors.Is(err, ErrTooManySubtitles) {
+					response.Skipped = append(response.Skipped, subtitle)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := tx.DeleteVideo(ctx, req.From); err != nil {
+				return err
+			}
+
+			response.Video = *video
+			response.Subtitles, err = tx.GetSubtitlesByVideoID(ctx, video.ID, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(response)
+	}
+}
+
+func restoreVideo(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		err = repo.RestoreVideo(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Deleted video not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// reconcileSubtitleType checks an upload's declared type against its content and filename, so
+// e.g. a VTT file sent as type=srt isn't stored with broken timestamps. A contradicting type is
+// replaced by the detected one, or rejected with 400 when the request has ?strict=true
+func reconcileSubtitleType(c *fiber.Ctx, fileType, filename, content string) (string, error) {
+	sniffed := sniffSubtitleType(filename, content)
+	if sniffed == "" || sameSubtitleFormat(sniffed, fileType) {
+		return fileType, nil
+	}
+
+	if c.QueryBool("strict") {
+		return "", fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("Declared type %s doesn't match the file, which looks like %s", fileType, sniffed))
+	}
+
+	slog.Info("Using detected subtitle type instead of the declared one",
+		"request_id", requestID(c), "declared", fileType, "detected", sniffed, "filename", filename)
+	return sniffed, nil
+}
+
+// subtitleLimitError is the response to a subtitle that would take a video past its limit
+func subtitleLimitError(repo *Repository) error {
+	return fiber.NewError(fiber.StatusUnprocessableEntity,
+		fmt.Sprintf("Video already has the maximum of %d subtitles", repo.maxSubtitlesPerVideo))
+}
+
+// uploadToSRT converts an uploaded subtitle to SRT. MicroDVD timings are frame numbers, so
+// they are converted using the fps form field
+func uploadToSRT(c *fiber.Ctx, content, fileType string) (string, error) {
+	if fileType != "sub" {
+		return convertToSRT(content, fileType), nil
+	}
+
+	fps := defaultMicroDVDFrameRate
+	if value := c.FormValue("fps"); value != "" {
+		var err error
+		fps, err = strconv.ParseFloat(value, 64)
+		if err != nil || fps <= 0 || fps > 1000 {
+			return "", fiber.NewError(fiber.StatusBadRequest, "Invalid frame rate, expected a positive number like 25 or 23.976")
+		}
+	}
+	return microDVDToSRT(normalizeNewlines(content), fps), nil
+}
+
+// uploadedSubtitleContent reads a subtitle uploaded as the file field, or pasted as the content
+// field, returning the file's name if there is one
+func uploadedSubtitleContent(c *fiber.Ctx, maxSize int) (content, filename string, err error) {
+	pasted := c.FormValue("content")
+	file, err := c.FormFile("file")
+	if err != nil {
+		if pasted == "" {
+			return "", "", fiber.NewError(fiber.StatusBadRequest, "No file uploaded or content pasted")
+		}
+		if len(pasted) > maxSize {
+			return "", "", fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("Content exceeds the maximum size of %d bytes", maxSize))
+		}
+		return pasted, "", nil
+	}
+	if pasted != "" {
+		return "", "", fiber.NewError(fiber.StatusBadRequest, "Upload a file or paste content, not both")
+	}
+	if file.Size > int64(maxSize) {
+		return "", "", fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds the maximum size of %d bytes", maxSize))
+	}
+
+	fileContent, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer fileContent.Close()
+
+	data, err := io.ReadAll(fileContent)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), file.Filename, nil
+}
+
+func uploadSubtitle(repo *Repository, maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		videoID := c.FormValue("video_id")
+		videoIDInt, err := strconv.Atoi(videoID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid video ID")
+		}
+
+		language, err := normalizeLanguage(c.FormValue("language"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		contentStr, filename, err := uploadedSubtitleContent(c, maxSize)
+		if err != nil {
+			return err
+		}
+
+		fileType, ok := resolveSubtitleType(c.FormValue("type"), contentStr)
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Unsupported subtitle type: "+fileType)
+		}
+		fileType, err = reconcileSubtitleType(c, fileType, filename, contentStr)
+		if err != nil {
+			return err
+		}
+
+		originalContent := contentStr
+
+		// Convert to SRT if necessary
+		contentStr, err = uploadToSRT(c, contentStr, fileType)
+		if err != nil {
+			return err
+		}
+		cues, err := parseSRT(contentStr)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid subtitle file: "+err.Error())
+		}
+
+		// Optionally sort, renumber and fix overlapping cues
+		if overlap := c.Query("normalize"); overlap != "" {
+			if !isOverlapMode(overlap) {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid normalize mode, expected keep, clip or merge")
+			}
+			contentStr = formatSRT(normalizeCues(cues, overlap))
+		}
+
+		// Save to database (always as SRT)
+		var id int64
+		if c.QueryBool("overwrite") {
+			id, err = repo.ReplaceSubtitle(ctx, videoIDInt, language, "srt", contentStr, fileType, originalContent)
+		} else {
+			id, err = repo.CreateSubtitle(ctx, videoIDInt, language, "srt", contentStr, fileType, originalContent)
+		}
+		if errors.Is(err, ErrSubtitleExists) {
+			return fiber.NewError(fiber.StatusConflict, "A subtitle for this language already exists, use ?overwrite=true to replace it")
+		}
+		if errors.Is(err, ErrTooManySubtitles) {
+			return subtitleLimitError(repo)
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"id": id, "success": true})
+	}
+}
+
+// ImportCaptionsRequest describes a caption file to import into a video
+type ImportCaptionsRequest struct {
+	URL      string `json:"url"`
+	Language string `json:"language"`
+	Type     string `json:"type"`
+}
+
+func importCaptions(repo *Repository, maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
+
+		var req ImportCaptionsRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		req.URL = strings.TrimSpace(req.URL)
+		req.Language = strings.TrimSpace(req.Language)
+
+		video, err := repo.GetVideoByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		// Without a URL, fall back to the video's own YouTube caption track
+		captionURL := req.URL
+		if captionURL == "" {
+			if req.Language == "" {
+				return fiber.NewError(fiber.StatusBadRequest, "Either url or language is required")
+			}
+			captionURL = youtubeTimedTextURL(video.VideoID, req.Language)
+		}
+
+		file, err := fetchCaptionFile(ctx, captionURL, maxSize)
+		if errors.Is(err, ErrInvalidCaptionURL) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadGateway, err.Error())
+		}
+		if strings.TrimSpace(file.Content) == "" {
+			return fiber.NewError(fiber.StatusNotFound, "No captions available at the given URL")
+		}
+
+		if req.Type != "" {
+			file.Type = req.Type
+		}
+		if req.Language != "" {
+			file.Language = req.Language
+		}
+		fileType, ok := resolveSubtitleType(file.Type, file.Content)
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Unsupported caption type: "+fileType)
+		}
+		file.Type = fileType
+		if file.Language == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Could not infer the caption language, please specify it")
+		}
+
+		language, err := normalizeLanguage(file.Language)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		content := convertToSRT(file.Content, file.Type)
+		if err := validateSRT(content); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid subtitle file: "+err.Error())
+		}
+
+		var subtitleID int64
+		if c.QueryBool("overwrite") {
+			subtitleID, err = repo.ReplaceSubtitle(ctx, video.ID, language, "srt", content, file.Type, file.Content)
+		} else {
+			subtitleID, err = repo.CreateSubtitle(ctx, video.ID, language, "srt", content, file.Type, file.Content)
+		}
+		if errors.Is(err, ErrSubtitleExists) {
+			return fiber.NewError(fiber.StatusConflict, "A subtitle for this language already exists, use ?overwrite=true to replace it")
+		}
+		if errors.Is(err, ErrTooManySubtitles) {
+			return subtitleLimitError(repo)
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"id": subtitleID, "success": true, "language": language, "type": file.Type})
+	}
+}
+
+// SubtitlePreview is the result of converting an upload without saving it
+type SubtitlePreview struct {
+	Type     string   `json:"type"`
+	Content  string   `json:"content"`
+	Valid    bool     `json:"valid"`
+	Error    string   `json:"error,omitempty"`
+	CueCount int      `json:"cue_count"`
+	Warnings []string `json:"warnings"`
+}
+
+func previewSubtitle(maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "No file uploaded")
+		}
+		if file.Size > int64(maxSize) {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds the maximum size of %d bytes", maxSize))
+		}
+
+		fileContent, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer fileContent.Close()
+
+		content, err := io.ReadAll(fileContent)
+		if err != nil {
 			return err
 		}
 
-		return c.JSON(videos)
+		fileType, ok := resolveSubtitleType(c.FormValue("type"), string(content))
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "Unsupported subtitle type: "+fileType)
+		}
+		fileType, err = reconcileSubtitleType(c, fileType, file.Filename, string(content))
+		if err != nil {
+			return err
+		}
+
+		srt, err := uploadToSRT(c, string(content), fileType)
+		if err != nil {
+			return err
+		}
+
+		preview := SubtitlePreview{
+			Type:     fileType,
+			Content:  srt,
+			Warnings: []string{},
+		}
+
+		// An invalid conversion is still a successful preview, so report it in the body
+		cues, err := parseSRT(preview.Content)
+		if err != nil {
+			preview.Error = err.Error()
+		} else {
+			preview.Valid = true
+			preview.CueCount = len(cues)
+			preview.Warnings = cueWarnings(cues)
+		}
+
+		return c.JSON(preview)
 	}
 }
 
-func addVideo(repo *Repository) fiber.Handler {
+// BulkUploadResult reports the outcome of a single file in a bulk subtitle upload
+type BulkUploadResult struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Type     string `json:"type"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// errBulkRollback aborts a bulk upload transaction when one of its files fails
+var errBulkRollback = errors.New("bulk upload rolled back")
+
+func uploadSubtitlesBulk(repo *Repository, maxSize int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		videoID := c.FormValue("video_id")
+		videoIDInt, err := strconv.Atoi(videoID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid video ID")
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid multipart form")
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "No files uploaded")
+		}
+
+		// Languages may be given as a parallel list of form fields, otherwise
+		// they're taken from the filename suffix (e.g. "movie.en.srt")
+		languages := form.Value["language"]
+
+		results := make([]BulkUploadResult, len(files))
+		var subtitles []Subtitle
+		var indexes []int
+		for i, file := range files {
+			language := ""
+			if i < len(languages) {
+				language = strings.TrimSpace(languages[i])
+			}
+			fileType, fileLanguage := subtitleInfoFromFilename(file.Filename)
+			if language == "" {
+				language = fileLanguage
+			}
+
+			results[i] = BulkUploadResult{
+				Filename: file.Filename,
+				Language: language,
+				Type:     fileType,
+			}
+
+			if file.Size > int64(maxSize) {
+				results[i].Error = fmt.Sprintf("file exceeds the maximum size of %d bytes", maxSize)
+				continue
+			}
+			if language == "" {
+				results[i].Error = "missing language"
+				continue
+			}
+			language, err = normalizeLanguage(language)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Language = language
+
+			fileContent, err := file.Open()
+			if err != nil {
+				results[i].Error = "failed to open file"
+				continue
+			}
+			content, err := io.ReadAll(fileContent)
+			fileContent.Close()
+			if err != nil {
+				results[i].Error = "failed to read file"
+				continue
+			}
+
+			// Like single uploads, a file without a subtitle extension is detected from its content,
+			// and an extension that contradicts the content is corrected or, with ?strict=true, rejected
+			fileType, _ = resolveSubtitleType(fileType, string(content))
+			fileType, err = reconcileSubtitleType(c, fileType, file.Filename, string(content))
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Type = fileType
+
+			srt, err := uploadToSRT(c, string(content), fileType)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			if err := validateSRT(srt); err != nil {
+				results[i].Error = "invalid subtitle file: " + err.Error()
+				continue
+			}
+
+			subtitles = append(subtitles, Subtitle{
+				VideoID:         videoIDInt,
+				Language:        language,
+				Type:            "srt",
+				Content:         srt,
+				OriginalType:    fileType,
+				OriginalContent: string(content),
+			})
+			indexes = append(indexes, i)
+		}
+
+		// With ?atomic=true, any failure rolls back the whole batch
+		atomic := c.QueryBool("atomic")
+		if atomic {
+			for _, result := range results {
+				if result.Error != "" {
+					return c.Status(fiber.StatusBadRequest).JSON(results)
+				}
+			}
+		}
+
+		insertErrs := make([]error, len(subtitles))
+		failed := false
+		err = repo.WithTx(ctx, func(tx *Repository) error {
+			failed = false
+			for j, sub := range subtitles {
+				_, insertErrs[j] = tx.CreateSubtitle(ctx, sub.VideoID, sub.Language, sub.Type, sub.Content, sub.OriginalType, sub.OriginalContent)
+				failed = failed || insertErrs[j] != nil
+			}
+			if atomic && failed {
+				return errBulkRollback
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBulkRollback) {
+			return err
+		}
+
+		for j, i := range indexes {
+			switch {
+			case errors.Is(insertErrs[j], ErrSubtitleExists):
+				results[i].Error = "subtitle for this language already exists"
+			case errors.Is(insertErrs[j], ErrTooManySubtitles):
+				results[i].Error = fmt.Sprintf("video already has the maximum of %d subtitles", repo.maxSubtitlesPerVideo)
+			case insertErrs[j] != nil:
+				results[i].Error = "failed to save subtitle"
+				slog.Error("Failed to save subtitle", "filename", results[i].Filename, "error", insertErrs[j])
+			default:
+				results[i].Success = true
+			}
+		}
+
+		if atomic && failed {
+			for i := range results {
+				if results[i].Success {
+					results[i].Success = false
+					results[i].Error = "rolled back"
+				}
+			}
+			return c.Status(fiber.StatusConflict).JSON(results)
+		}
+
+		return c.JSON(results)
+	}
+}
+
+func updateSubtitle(repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
 
 		var req struct {
-			URL   string `json:"url"`
-			Title string `json:"title"`
+			Content  string `json:"content"`
+			Language string `json:"language"`
 		}
 
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
 		}
 
-		id, err := repo.CreateVideo(ctx, req.URL, req.Title)
+		if err := validateSRT(req.Content); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid SRT: "+err.Error())
+		}
+
+		// Language is optional, an empty value keeps the current one
+		language := ""
+		if strings.TrimSpace(req.Language) != "" {
+			language, err = normalizeLanguage(req.Language)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		}
+
+		err = repo.UpdateSubtitle(ctx, idInt, language, req.Content)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if errors.Is(err, ErrSubtitleExists) {
+			return fiber.NewError(fiber.StatusConflict, "A subtitle for this language already exists")
+		}
 		if err != nil {
 			return err
 		}
 
-		return c.JSON(fiber.Map{"id": id})
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(subtitle)
 	}
 }
 
-func deleteVideo(repo *Repository) fiber.Handler {
+// renameSubtitle changes a mislabeled subtitle's language, leaving its content alone
+func renameSubtitle(repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 
-		id := c.Params("id")
-		idInt, err := strconv.Atoi(id)
+		idInt, err := strconv.Atoi(c.Params("id"))
 		if err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 		}
 
-		err = repo.DeleteVideo(ctx, idInt)
+		var req struct {
+			Language string `json:"language"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+		if strings.TrimSpace(req.Language) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Language is required")
+		}
+
+		language, err := normalizeLanguage(req.Language)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		err = repo.RenameSubtitle(ctx, idInt, language)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if errors.Is(err, ErrSubtitleExists) {
+			return fiber.NewError(fiber.StatusConflict, "A subtitle for this language already exists")
+		}
 		if err != nil {
 			return err
 		}
-		return c.JSON(fiber.Map{"success": true})
+
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(subtitle)
 	}
 }
 
-func uploadSubtitle(repo *Repository) fiber.Handler {
+func shiftSubtitle(repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 
-		videoID := c.FormValue("video_id")
-		videoIDInt, err := strconv.Atoi(videoID)
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
 		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "Invalid video ID")
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 		}
 
-		language := c.FormValue("language")
-		fileType := c.FormValue("type")
+		offsetMs, err := strconv.Atoi(c.Query("offset"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid offset, expected an integer number of milliseconds")
+		}
 
-		file, err := c.FormFile("file")
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
 		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "No file uploaded")
+			return err
 		}
 
-		// Read file content
-		fileContent, err := file.Open()
+		cues, err := parseSRT(subtitle.Content)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Stored subtitle is not valid SRT: "+err.Error())
+		}
+
+		shifted, err := shiftCues(cues, time.Duration(offsetMs)*time.Millisecond, c.QueryBool("clamp"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error()+", use ?clamp=true to clamp at zero")
+		}
+
+		err = repo.UpdateSubtitle(ctx, idInt, "", formatSRT(shifted))
 		if err != nil {
 			return err
 		}
-		defer fileContent.Close()
 
-		content := make([]byte, file.Size)
-		_, err = fileContent.Read(content)
+		subtitle, err = repo.GetSubtitleByID(ctx, idInt)
 		if err != nil {
 			return err
 		}
 
-		contentStr := string(content)
+		return c.JSON(subtitle)
+	}
+}
+
+// splitSubtitle turns a subtitle with two languages stacked in each cue into one subtitle
+// per language, optionally deleting the original
+func splitSubtitle(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
 
-		// Convert VTT to SRT if necessary
-		if fileType == "vtt" {
-			contentStr = vttToSRT(contentStr)
+		idInt, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 		}
 
-		// Save to database (always as SRT)
-		err = repo.CreateSubtitle(ctx, videoIDInt, language, "srt", contentStr)
+		var req struct {
+			Languages      []string `json:"languages"`
+			DeleteOriginal bool     `json:"delete_original"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request")
+		}
+		if len(req.Languages) != 2 {
+			return fiber.NewError(fiber.StatusBadRequest, "Expected two languages, for the first and second line of each cue")
+		}
+		languages := make([]string, 2)
+		for i, value := range req.Languages {
+			languages[i], err = normalizeLanguage(value)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		}
+		if languages[0] == languages[1] {
+			return fiber.NewError(fiber.StatusBadRequest, "The two languages must be different")
+		}
+
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
 		if err != nil {
 			return err
 		}
 
-		return c.JSON(fiber.Map{"success": true})
+		cues, err := parseSRT(subtitle.Content)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Stored subtitle is not valid SRT: "+err.Error())
+		}
+
+		first, second := splitBilingualCues(cues)
+		if len(first) == 0 || len(second) == 0 {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Subtitle has no cues with a second line to split off")
+		}
+
+		ids := make([]int64, 2)
+		err = repo.WithTx(ctx, func(tx *Repository) error {
+			// Deleting first lets one of the new subtitles reuse the original's language
+			if req.DeleteOriginal {
+				if err := tx.DeleteSubtitle(ctx, subtitle.ID); err != nil {
+					return err
+				}
+			}
+
+			for i, part := range [][]Cue{first, second} {
+				content := formatSRT(part)
+				ids[i], err = tx.CreateSubtitle(ctx, subtitle.VideoID, languages[i], "srt", content, "srt", content)
+				if errors.Is(err, ErrSubtitleExists) {
+					return fiber.NewError(fiber.StatusConflict, "A subtitle for "+languages[i]+" already exists")
+				}
+				if errors.Is(err, ErrTooManySubtitles) {
+					return subtitleLimitError(repo)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"ids": ids})
 	}
 }
 
-func deleteSubtitle(repo *Repository) fiber.Handler {
+func reprocessSubtitle(repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 
 		id := c.Params("id")
 		idInt, err := strconv.Atoi(id)
@@ -440,46 +2583,99 @@ func deleteSubtitle(repo *Repository) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
 		}
 
-		err = repo.DeleteSubtitle(ctx, idInt)
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
 		if err != nil {
 			return err
 		}
-		return c.JSON(fiber.Map{"success": true})
+
+		cues, err := parseSRT(subtitle.Content)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Stored subtitle is not valid SRT: "+err.Error())
+		}
+
+		cleaned, summary := cleanCues(cues)
+		if len(cleaned) == 0 {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Reprocessing would leave the subtitle without any cues")
+		}
+
+		content := formatSRT(cleaned)
+		summary.Changed = content != subtitle.Content
+		if summary.Changed {
+			if err := repo.UpdateSubtitle(ctx, idInt, "", content); err != nil {
+				return err
+			}
+			subtitle, err = repo.GetSubtitleByID(ctx, idInt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"summary":  summary,
+			"subtitle": subtitle,
+		})
 	}
 }
 
-func vttToSRT(vtt string) string {
-	lines := strings.Split(vtt, "\n")
-	var srtLines []string
-	counter := 1
-	skipHeader := true
+func normalizeSubtitle(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
 
-	for i := 0; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
 
-		// Skip VTT header
-		if skipHeader {
-			if strings.HasPrefix(line, "WEBVTT") || line == "" {
-				continue
-			}
-			skipHeader = false
+		overlap := c.Query("overlap", overlapKeep)
+		if !isOverlapMode(overlap) {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid overlap mode, expected keep, clip or merge")
 		}
 
-		// Check if line is a timestamp
-		if strings.Contains(line, "-->") {
-			// Add counter
-			srtLines = append(srtLines, strconv.Itoa(counter))
-			counter++
+		subtitle, err := repo.GetSubtitleByID(ctx, idInt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Subtitle not found")
+		}
+		if err != nil {
+			return err
+		}
 
-			// Convert timestamp format (remove millisecond dot to comma)
-			line = strings.ReplaceAll(line, ".", ",")
-			srtLines = append(srtLines, line)
-		} else if line != "" {
-			srtLines = append(srtLines, line)
-		} else {
-			srtLines = append(srtLines, "")
+		cues, err := parseSRT(subtitle.Content)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "Stored subtitle is not valid SRT: "+err.Error())
+		}
+
+		err = repo.UpdateSubtitle(ctx, idInt, "", formatSRT(normalizeCues(cues, overlap)))
+		if err != nil {
+			return err
+		}
+
+		subtitle, err = repo.GetSubtitleByID(ctx, idInt)
+		if err != nil {
+			return err
 		}
+
+		return c.JSON(subtitle)
 	}
+}
+
+func deleteSubtitle(repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		id := c.Params("id")
+		idInt, err := strconv.Atoi(id)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+		}
 
-	return strings.Join(srtLines, "\n")
+		err = repo.DeleteSubtitle(ctx, idInt)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
 }
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMain(m *testing.M) {
+	// Keep migration and request logs out of the test output
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestRepository opens a migrated database in a temporary directory
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+
+	repo, err := NewRepository(filepath.Join(t.TempDir(), "test.db"), DefaultDatabaseConfig)
+	if err != nil {
+		t.Fatalf("NewRepository: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// newTestApp returns an app that renders errors the way the server does
+func newTestApp() *fiber.App {
+	return fiber.New(fiber.Config{ErrorHandler: customErrorHandler})
+}
+
+// multipartBody encodes fields and a single file as multipart/form-data
+func multipartBody(t *testing.T, fields map[string]string, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if filename != "" {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, w.FormDataContentType()
+}
+
+func TestUploadSubtitleStoresWholeFile(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	videoID, err := repo.CreateVideo(ctx, "dQw4w9WgXcQ", youtubeCanonicalURL("dQw4w9WgXcQ"), "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Large enough that a single Read of a multipart file can come back short
+	var srt strings.Builder
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&srt, "%d\n00:%02d:%02d,000 --> 00:%02d:%02d,500\nLine number %d of the test subtitle\n\n",
+			i, i/60, i%60, i/60, i%60, i)
+	}
+	original := srt.String()
+	if len(original) < 8*1024 {
+		t.Fatalf("test subtitle is only %d bytes", len(original))
+	}
+
+	app := newTestApp()
+	app.Post("/subtitles", uploadSubtitle(repo, 1<<20))
+
+	body, contentType := multipartBody(t, map[string]string{
+		"video_id": fmt.Sprint(videoID),
+		"language": "en",
+		"type":     "srt",
+	}, "large.srt", original)
+	req := httptest.NewRequest("POST", "/subtitles", body)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("upload returned %d", resp.StatusCode)
+	}
+
+	var uploaded struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.GetSubtitleByID(ctx, uploaded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.OriginalContent) != len(original) {
+		t.Errorf("stored original is %d bytes, uploaded %d", len(stored.OriginalContent), len(original))
+	}
+	if len(stored.Content) != len(original) {
+		t.Errorf("stored content is %d bytes, uploaded %d", len(stored.Content), len(original))
+	}
+}
+
+func TestYouTubeVideoIDFromURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtube.com/watch?v=dQw4w9WgXcQ&t=30s", "dQw4w9WgXcQ", true},
+		{"https://m.youtube.com/watch?list=PL123&v=dQw4w9WgXcQ&index=2", "dQw4w9WgXcQ", true},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://youtu.be/dQw4w9WgXcQ?t=30s", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ?feature=share", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?start=30", "dQw4w9WgXcQ", true},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ?si=abc&list=PL123", "dQw4w9WgXcQ", true},
+		{"https://WWW.YOUTUBE.COM/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com:443/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ", true},
+		{"https://www.youtube.com/watch?v=a-b_c1234XY", "a-b_c1234XY", true},
+
+		{"https://www.youtube.com/watch?v=short", "", false},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQextra", "", false},
+		{"https://www.youtube.com/watch?v=dQw4w9WgX%2FQ", "", false},
+		{"https://www.youtube.com/watch", "", false},
+		{"https://www.youtube.com/shorts/", "", false},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ/extra", "", false},
+		{"https://www.youtube.com/channel/dQw4w9WgXcQ", "", false},
+		{"https://youtu.be/a/b", "", false},
+		{"https://youtu.be/", "", false},
+		{"https://notyoutube.com.evil/watch?v=dQw4w9WgXcQ", "", false},
+		{"https://youtube.com.evil/watch?v=dQw4w9WgXcQ", "", false},
+		{"https://evilyoutu.be/dQw4w9WgXcQ", "", false},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", "", false},
+		{"dQw4w9WgXcQ", "", false},
+		{"", "", false},
+		{"://bad", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, ok := youtubeVideoIDFromURL(tt.url)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("youtubeVideoIDFromURL(%q) = %q, %v, want %q, %v", tt.url, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPreviewSubtitleUsesFrameRate(t *testing.T) {
+	app := newTestApp()
+	app.Post("/preview", previewSubtitle(1<<20))
+
+	body, contentType := multipartBody(t, map[string]string{"type": "sub", "fps": "25"}, "movie.sub", "{25}{50}Hello|world\n")
+	req := httptest.NewRequest("POST", "/preview", body)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("preview returned %d", resp.StatusCode)
+	}
+
+	var preview SubtitlePreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:01,000 --> 00:00:02,000\nHello\nworld\n\n"
+	if preview.Content != want {
+		t.Errorf("preview content = %q, want %q", preview.Content, want)
+	}
+}
+
+func TestMergeVideosIntoFullVideo(t *testing.T) {
+	repo := newTestRepository(t)
+	repo.maxSubtitlesPerVideo = 2
+	ctx := context.Background()
+	ids := seedSubtitles(t, repo, 2, "en", "fr")
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+	if _, err := repo.CreateSubtitle(ctx, ids[0], "de", "srt", srt, "srt", srt); !errors.Is(err, ErrTooManySubtitles) {
+		t.Fatalf("target video isn't full, CreateSubtitle returned %v", err)
+	}
+
+	app := newTestApp()
+	app.Post("/videos/:id/merge", mergeVideos(repo))
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/videos/%d/merge", ids[0]), strings.NewReader(fmt.Sprintf(`{"from":%d}`, ids[1])))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("merge returned %d", resp.StatusCode)
+	}
+
+	var merged MergeVideosResponse
+	if err := json.NewDecoder(resp.Body).Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Subtitles) != 2 {
+		t.Errorf("merged video has %d subtitles, want 2", len(merged.Subtitles))
+	}
+	if len(merged.Skipped) != 2 {
+		t.Errorf("merge skipped %d subtitles, want 2", len(merged.Skipped))
+	}
+
+	// Skipped subtitles stay on the source video, which comes back with them
+	if err := repo.RestoreVideo(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, skipped := range merged.Skipped {
+		subtitle, err := repo.GetSubtitleByID(ctx, skipped.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subtitle.VideoID != ids[1] {
+			t.Errorf("skipped subtitle %d moved to video %d", skipped.ID, subtitle.VideoID)
+		}
+	}
+}
+
+func TestUploadSubtitlesBulkDetectsTypes(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1)[0]
+
+	files := []struct {
+		name, content string
+	}{
+		{"movie.en.srt", "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n"},
+		{"movie.fr.sub", "{25}{50}Bonjour\n"},
+		{"movie.de.txt", "1\n00:00:01,000 --> 00:00:02,000\nHallo\n"},
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("video_id", fmt.Sprint(videoID))
+	w.WriteField("fps", "25")
+	for _, f := range files {
+		part, err := w.CreateFormFile("files", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f.content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.Post("/subtitles/bulk", uploadSubtitlesBulk(repo, 1<<20))
+	req := httptest.NewRequest("POST", "/subtitles/bulk", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("bulk upload returned %d", resp.StatusCode)
+	}
+	var results []BulkUploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		language, fileType, content string
+	}{
+		{"en", "vtt", "1\n00:00:01,000 --> 00:00:02,000\nHello"},
+		{"fr", "sub", "1\n00:00:01,000 --> 00:00:02,000\nBonjour"},
+		{"de", "srt", "1\n00:00:01,000 --> 00:00:02,000\nHallo"},
+	}
+	for i, tt := range tests {
+		if !results[i].Success || results[i].Type != tt.fileType {
+			t.Errorf("%s: result %+v, want success as %s", files[i].name, results[i], tt.fileType)
+			continue
+		}
+		subtitle, err := repo.GetSubtitleByLanguage(ctx, videoID, tt.language)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(subtitle.Content) != tt.content {
+			t.Errorf("%s: stored %q, want %q", files[i].name, subtitle.Content, tt.content)
+		}
+	}
+}
+
+func TestDownloadSubtitleByteRanges(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1, "en")[0]
+	subtitle, err := repo.GetSubtitleByLanguage(ctx, videoID, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := subtitle.Content
+	size := len(content)
+
+	app := newTestApp()
+	app.Get("/subtitles/:id/download", downloadSubtitle(repo))
+	url := fmt.Sprintf("/subtitles/%d/download", subtitle.ID)
+
+	full, err := app.Test(httptest.NewRequest("GET", url, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := full.Header.Get(fiber.HeaderETag)
+	if etag == "" {
+		t.Fatal("download has no ETag")
+	}
+
+	tests := []struct {
+		name        string
+		rangeHeader string
+		ifRange     string
+		wantStatus  int
+		wantBody    string
+		wantRange   string
+	}{
+		{"no range", "", "", fiber.StatusOK, content, ""},
+		{"prefix", "bytes=0-4", "", fiber.StatusPartialContent, content[:5], fmt.Sprintf("bytes 0-4/%d", size)},
+		{"open ended", "bytes=5-", "", fiber.StatusPartialContent, content[5:], fmt.Sprintf("bytes 5-%d/%d", size-1, size)},
+		{"suffix", "bytes=-5", "", fiber.StatusPartialContent, content[size-5:], fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size)},
+		{"multiple ranges", "bytes=0-1,3-4", "", fiber.StatusOK, content, ""},
+		{"past the end", fmt.Sprintf("bytes=%d-", size+10), "", fiber.StatusRequestedRangeNotSatisfiable, "", fmt.Sprintf("bytes */%d", size)},
+		{"matching if-range", "bytes=0-4", etag, fiber.StatusPartialContent, content[:5], fmt.Sprintf("bytes 0-4/%d", size)},
+		{"stale if-range", "bytes=0-4", `"stale"`, fiber.StatusOK, content, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", url, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set(fiber.HeaderRange, tt.rangeHeader)
+			}
+			if tt.ifRange != "" {
+				req.Header.Set(fiber.HeaderIfRange, tt.ifRange)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderAcceptRanges); got != "bytes" {
+				t.Errorf("Accept-Ranges is %q, want bytes", got)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentRange); got != tt.wantRange {
+				t.Errorf("Content-Range is %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantStatus == fiber.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("body %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestImportVideo(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	if _, err := repo.CreateVideo(ctx, "existing000", youtubeCanonicalURL("existing000"), "Existing"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.Post("/videos/import", importVideo(repo, 1<<20))
+
+	srt := `"1\n00:00:01,000 --> 00:00:02,000\nHello\n"`
+	vtt := `"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nBonjour\n"`
+	tests := []struct {
+		name       string
+		body       string
+		videoID    string
+		wantStatus int
+		wantTypes  map[string]string // language to original type of the stored subtitles
+	}{
+		{"video with subtitles",
+			`{"url": "https://youtu.be/dQw4w9WgXcQ", "title": "Test", "subtitles": [
+				{"language": "EN", "type": "srt", "content": ` + srt + `},
+				{"language": "French", "content": ` + vtt + `}]}`,
+			"dQw4w9WgXcQ", fiber.StatusCreated, map[string]string{"en": "srt", "fr": "vtt"}},
+		{"video without subtitles",
+			`{"url": "https://www.youtube.com/watch?v=a-b_c1234XY", "title": "Empty"}`,
+			"a-b_c1234XY", fiber.StatusCreated, map[string]string{}},
+		{"repeated language",
+			`{"url": "https://youtu.be/repeated000", "subtitles": [
+				{"language": "en", "content": ` + srt + `}, {"language": "en", "content": ` + srt + `}]}`,
+			"repeated000", fiber.StatusConflict, nil},
+		{"invalid subtitle",
+			`{"url": "https://youtu.be/invalidsub0", "subtitles": [{"language": "en", "type": "srt", "content": "not a subtitle"}]}`,
+			"invalidsub0", fiber.StatusBadRequest, nil},
+		{"invalid language",
+			`{"url": "https://youtu.be/invalidlang", "subtitles": [{"language": "xx", "content": ` + srt + `}]}`,
+			"invalidlang", fiber.StatusBadRequest, nil},
+		{"existing video",
+			`{"url": "https://youtu.be/existing000", "subtitles": [{"language": "en", "content": ` + srt + `}]}`,
+			"existing000", fiber.StatusConflict, map[string]string{}},
+		{"invalid url", `{"url": "https://example.com/watch?v=dQw4w9WgXcQ"}`, "", fiber.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/videos/import", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("import returned %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.videoID == "" {
+				return
+			}
+
+			// Failed imports leave nothing behind
+			video, err := repo.GetVideoByURL(ctx, tt.videoID)
+			if tt.wantTypes == nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("GetVideoByURL after a failed import returned %v, want sql.ErrNoRows", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subtitles, err := repo.ListSubtitleMetadata(ctx, video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, subtitle := range subtitles {
+				got[subtitle.Language] = subtitle.OriginalType
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantTypes) {
+				t.Errorf("stored subtitles %v, want %v", got, tt.wantTypes)
+			}
+
+			if resp.StatusCode == fiber.StatusCreated {
+				var created ImportVideoResponse
+				if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+					t.Fatal(err)
+				}
+				if created.ID != video.ID || len(created.SubtitleIDs) != len(tt.wantTypes) {
+					t.Errorf("response %+v doesn't match video %d with %d subtitles", created, video.ID, len(tt.wantTypes))
+				}
+			}
+		})
+	}
+}
+
+func TestSplitSubtitle(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	videoID := seedSubtitles(t, repo, 1, "de")[0]
+
+	bilingual := "1\n00:00:01,000 --> 00:00:02,000\nHello\nBonjour\n\n2\n00:00:03,000 --> 00:00:04,000\nBye\nAu revoir\n"
+	original, err := repo.CreateSubtitle(ctx, videoID, "en", "srt", bilingual, "srt", bilingual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monolingual, err := repo.GetSubtitleByLanguage(ctx, videoID, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.Post("/subtitles/:id/split", splitSubtitle(repo))
+	split := func(id int64, body string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/subtitles/%d/split", id), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		id         int64
+		body       string
+		wantStatus int
+	}{
+		{"one language", original, `{"languages": ["en"]}`, fiber.StatusBadRequest},
+		{"same languages", original, `{"languages": ["en", "EN"]}`, fiber.StatusBadRequest},
+		{"missing subtitle", 999999, `{"languages": ["en", "fr"]}`, fiber.StatusNotFound},
+		{"nothing to split", int64(monolingual.ID), `{"languages": ["de", "fr"]}`, fiber.StatusUnprocessableEntity},
+		// The original still holds "en"
+		{"existing language", original, `{"languages": ["en", "fr"]}`, fiber.StatusConflict},
+	}
+	for _, tt := range tests {
+		if resp := split(tt.id, tt.body); resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: split returned %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+	}
+
+	// A failed split leaves nothing behind
+	if _, err := repo.GetSubtitleByLanguage(ctx, videoID, "fr"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSubtitleByLanguage(fr) after a failed split returned %v, want sql.ErrNoRows", err)
+	}
+
+	resp := split(original, `{"languages": ["en", "fr"], "delete_original": true}`)
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("split returned %d", resp.StatusCode)
+	}
+	var created struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.IDs) != 2 {
+		t.Fatalf("split returned IDs %v, want two", created.IDs)
+	}
+
+	if _, err := repo.GetSubtitleByID(ctx, int(original)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSubtitleByID of the original returned %v, want sql.ErrNoRows", err)
+	}
+	wants := map[string]string{
+		"en": "Hello\n\n2\n00:00:03,000 --> 00:00:04,000\nBye",
+		"fr": "Bonjour\n\n2\n00:00:03,000 --> 00:00:04,000\nAu revoir",
+	}
+	for i, language := range []string{"en", "fr"} {
+		subtitle, err := repo.GetSubtitleByID(ctx, created.IDs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "1\n00:00:01,000 --> 00:00:02,000\n" + wants[language]
+		if subtitle.Language != language || strings.TrimSpace(subtitle.Content) != want {
+			t.Errorf("subtitle %d is %s %q, want %s %q", subtitle.ID, subtitle.Language, subtitle.Content, language, want)
+		}
+	}
+}
+
+func TestMergeVideos(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	ids := seedSubtitles(t, repo, 2, "en")
+	target, source := ids[0], ids[1]
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nBonjour\n"
+	if _, err := repo.CreateSubtitle(ctx, source, "fr", "srt", srt, "srt", srt); err != nil {
+		t.Fatal(err)
+	}
+	sourceEnglish, err := repo.GetSubtitleByLanguage(ctx, source, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.Post("/videos/:id/merge", mergeVideos(repo))
+	merge := func(id int, body string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("POST", fmt.Sprintf("/videos/%d/merge", id), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		id         int
+		body       string
+		wantStatus int
+	}{
+		{"into itself", target, fmt.Sprintf(`{"from": %d}`, target), fiber.StatusBadRequest},
+		{"invalid body", target, `{"from": "x"}`, fiber.StatusBadRequest},
+		{"missing target", 999999, fmt.Sprintf(`{"from": %d}`, source), fiber.StatusNotFound},
+		{"missing source", target, `{"from": 999999}`, fiber.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := merge(tt.id, tt.body); resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: merge returned %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+	}
+
+	resp := merge(target, fmt.Sprintf(`{"from": %d}`, source))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("merge returned %d", resp.StatusCode)
+	}
+	var merged MergeVideosResponse
+	if err := json.NewDecoder(resp.Body).Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+
+	// The target keeps its own English subtitle and gains the French one
+	var languages []string
+	for _, subtitle := range merged.Subtitles {
+		languages = append(languages, subtitle.Language)
+	}
+	if strings.Join(languages, ",") != "en,fr" {
+		t.Errorf("merged video has languages %v, want [en fr]", languages)
+	}
+	if len(merged.Skipped) != 1 || merged.Skipped[0].ID != sourceEnglish.ID {
+		t.Errorf("merge skipped %+v, want the source's English subtitle %d", merged.Skipped, sourceEnglish.ID)
+	}
+	if _, err := repo.GetVideoByID(ctx, source); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetVideoByID of the source returned %v, want sql.ErrNoRows", err)
+	}
+
+	// Merging the now deleted source again finds nothing
+	if resp := merge(target, fmt.Sprintf(`{"from": %d}`, source)); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("second merge returned %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// metricsRegistry holds all application metrics, exposed on /metrics when enabled
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "subbed_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	dbOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "subbed_db_operation_duration_seconds",
+		Help:    "Database operation latency by repository method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		dbOperationDuration,
+	)
+}
+
+// observeDBOperation records how long a repository method took, use as
+// defer observeDBOperation("name", time.Now())
+func observeDBOperation(operation string, start time.Time) {
+	dbOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// metricsMiddleware records request durations, labelled by the matched route
+// pattern rather than the raw path to keep label cardinality bounded
+func metricsMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		httpRequestDuration.
+			WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(responseStatus(c, err))).
+			Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// metricsHandler serves metrics in the Prometheus format. If token is set,
+// requests must carry it as "Authorization: Bearer <token>".
+func metricsHandler(token string) fiber.Handler {
+	handler := adaptor.HTTPHandler(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+
+	return func(c *fiber.Ctx) error {
+		if token != "" {
+			expected := "Bearer " + token
+			if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), []byte(expected)) != 1 {
+				return fiber.ErrUnauthorized
+			}
+		}
+		return handler(c)
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// migration is a single schema change, applied inside a transaction
+type migration struct {
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations is the ordered list of schema changes. A migration's version is
+// its position in the slice (starting at 1), so never reorder or remove entries;
+// append new ones to the end instead.
+var migrations = []migration{
+	{
+		description: "create videos and subtitles tables",
+		up: func(tx *sql.Tx) error {
+			// IF NOT EXISTS keeps databases created before migrations were introduced intact
+			_, err := tx.Exec(`
+				CREATE TABLE IF NOT EXISTS videos (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					original_url TEXT NOT NULL UNIQUE,
+					title TEXT NOT NULL
+				)
+			`)
+			if err != nil {
+				return fmt.Errorf("failed to create videos table: %w", err)
+			}
+
+			_, err = tx.Exec(`
+				CREATE TABLE IF NOT EXISTS subtitles (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					video_id INTEGER NOT NULL,
+					language TEXT NOT NULL,
+					type TEXT NOT NULL,
+					content TEXT NOT NULL,
+					FOREIGN KEY (video_id) REFERENCES videos(id) ON DELETE CASCADE
+				)
+			`)
+			if err != nil {
+				return fmt.Errorf("failed to create subtitles table: %w", err)
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "add created_at and updated_at to videos and subtitles",
+		up: func(tx *sql.Tx) error {
+			// SQLite doesn't allow non-constant defaults in ADD COLUMN, so backfill existing rows
+			for _, table := range []string{"videos", "subtitles"} {
+				for _, column := range []string{"created_at", "updated_at"} {
+					_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TIMESTAMP", table, column))
+					if err != nil {
+						return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+					}
+				}
+
+				const now = "strftime('%Y-%m-%dT%H:%M:%SZ', 'now')"
+				_, err := tx.Exec(fmt.Sprintf("UPDATE %s SET created_at = %s, updated_at = %s", table, now, now))
+				if err != nil {
+					return fmt.Errorf("failed to backfill %s timestamps: %w", table, err)
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "add videos.video_id for exact lookups",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("ALTER TABLE videos ADD COLUMN video_id TEXT NOT NULL DEFAULT ''")
+			if err != nil {
+				return fmt.Errorf("failed to add videos.video_id: %w", err)
+			}
+
+			// Collect rows first, the transaction holds a single connection
+			rows, err := tx.Query("SELECT id, original_url FROM videos")
+			if err != nil {
+				return fmt.Errorf("failed to query videos: %w", err)
+			}
+			videoIDs := map[int]string{}
+			for rows.Next() {
+				var id int
+				var originalURL string
+				if err := rows.Scan(&id, &originalURL); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan video: %w", err)
+				}
+
+				// Older rows may hold a bare video ID instead of a URL
+				videoID, ok := migration3VideoIDFromURL(originalURL)
+				if !ok {
+					videoID = strings.TrimSpace(originalURL)
+				}
+				videoIDs[id] = videoID
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate videos: %w", err)
+			}
+
+			for id, videoID := range videoIDs {
+				if _, err := tx.Exec("UPDATE videos SET video_id = ? WHERE id = ?", videoID, id); err != nil {
+					return fmt.Errorf("failed to backfill video_id for video %d: %w", id, err)
+				}
+			}
+
+			_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_videos_video_id ON videos(video_id)")
+			if err != nil {
+				return fmt.Errorf("failed to create videos.video_id index: %w", err)
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "add unique constraint on subtitles video_id, language and type",
+		up: func(tx *sql.Tx) error {
+			// Keep only the most recent of any existing duplicates so the index can be created
+			result, err := tx.Exec(`
+				DELETE FROM subtitles
+				WHERE id NOT IN (
+					SELECT MAX(id) FROM subtitles GROUP BY video_id, language, type
+				)
+			`)
+			if err != nil {
+				return fmt.Errorf("failed to remove duplicate subtitles: %w", err)
+			}
+			if removed, _ := result.RowsAffected(); removed > 0 {
+				slog.Warn("Removed duplicate subtitles", "count", removed)
+			}
+
+			_, err = tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_subtitles_video_language_type ON subtitles(video_id, language, type)")
+			if err != nil {
+				return fmt.Errorf("failed to create subtitles unique index: %w", err)
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "add original_type and original_content to subtitles",
+		up: func(tx *sql.Tx) error {
+			for _, column := range []string{"original_type", "original_content"} {
+				_, err := tx.Exec(fmt.Sprintf("ALTER TABLE subtitles ADD COLUMN %s TEXT NOT NULL DEFAULT ''", column))
+				if err != nil {
+					return fmt.Errorf("failed to add subtitles.%s: %w", column, err)
+				}
+			}
+
+			// Existing subtitles were stored as-is or converted, the SRT is the best original we have
+			_, err := tx.Exec("UPDATE subtitles SET original_type = type, original_content = content")
+			if err != nil {
+				return fmt.Errorf("failed to backfill original subtitles: %w", err)
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "add index on subtitles.video_id",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_subtitles_video_id ON subtitles(video_id)")
+			if err != nil {
+				return fmt.Errorf("failed to create subtitles.video_id index: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		description: "add videos.deleted_at for soft deletes",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("ALTER TABLE videos ADD COLUMN deleted_at DATETIME")
+			if err != nil {
+				return fmt.Errorf("failed to add videos.deleted_at: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		description: "normalize subtitles.original_type",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE subtitles SET original_type = lower(trim(original_type))")
+			if err != nil {
+				return fmt.Errorf("failed to lowercase original types: %w", err)
+			}
+
+			// Unrecognized types were stored without conversion, so their content is SRT
+			_, err = tx.Exec(`UPDATE subtitles SET original_type = 'srt'
+				WHERE original_type NOT IN ('srt', 'vtt', 'ass', 'ssa', 'sbv', 'ttml', 'dfxp')`)
+			if err != nil {
+				return fmt.Errorf("failed to default unknown original types: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		description: "add subtitles.compressed, line_count and char_count",
+		up: func(tx *sql.Tx) error {
+			// Sizes can't be computed in SQL from compressed content, so they are stored on write
+			for _, column := range []string{"compressed", "line_count", "char_count"} {
+				_, err := tx.Exec(fmt.Sprintf("ALTER TABLE subtitles ADD COLUMN %s INTEGER NOT NULL DEFAULT 0", column))
+				if err != nil {
+					return fmt.Errorf("failed to add subtitles.%s: %w", column, err)
+				}
+			}
+
+			_, err := tx.Exec(`UPDATE subtitles SET
+				line_count = CASE WHEN rtrim(content, char(10)) = '' THEN 0
+					ELSE length(rtrim(content, char(10))) - length(replace(rtrim(content, char(10)), char(10), '')) + 1
+					END,
+				char_count = length(content)`)
+			if err != nil {
+				return fmt.Errorf("failed to backfill subtitle sizes: %w", err)
+			}
+			return nil
+		},
+	},
+	{
+		description: "add subtitles.cue_count and duration_ms",
+		up: func(tx *sql.Tx) error {
+			for _, column := range []string{"cue_count", "duration_ms"} {
+				_, err := tx.Exec(fmt.Sprintf("ALTER TABLE subtitles ADD COLUMN %s INTEGER NOT NULL DEFAULT 0", column))
+				if err != nil {
+					return fmt.Errorf("failed to add subtitles.%s: %w", column, err)
+				}
+			}
+
+			// Cues can only be counted by parsing, so backfill in batches to bound memory use
+			type row struct {
+				id         int
+				content    string
+				compressed bool
+			}
+			for afterID := 0; ; {
+				rows, err := tx.Query("SELECT id, content, compressed FROM subtitles WHERE id > ? ORDER BY id LIMIT 100", afterID)
+				if err != nil {
+					return fmt.Errorf("failed to query subtitles: %w", err)
+				}
+				var batch []row
+				for rows.Next() {
+					var r row
+					if err := rows.Scan(&r.id, &r.content, &r.compressed); err != nil {
+						rows.Close()
+						return fmt.Errorf("failed to scan subtitle: %w", err)
+					}
+					batch = append(batch, r)
+				}
+				rows.Close()
+				if err := rows.Err(); err != nil {
+					return fmt.Errorf("failed to query subtitles: %w", err)
+				}
+				if len(batch) == 0 {
+					return nil
+				}
+
+				for _, r := range batch {
+					content := r.content
+					if r.compressed {
+						if content, err = gunzipString(content); err != nil {
+							return fmt.Errorf("failed to decompress subtitle %d: %w", r.id, err)
+						}
+					}
+
+					cues, span := cueSummary(content)
+					_, err := tx.Exec("UPDATE subtitles SET cue_count = ?, duration_ms = ? WHERE id = ?", cues, span.Milliseconds(), r.id)
+					if err != nil {
+						return fmt.Errorf("failed to backfill subtitle %d: %w", r.id, err)
+					}
+				}
+				afterID = batch[len(batch)-1].id
+			}
+		},
+	},
+	{
+		description: "canonicalize video URLs and make videos.video_id unique",
+		up: func(tx *sql.Tx) error {
+			type video struct {
+				id       int
+				url      string
+				videoID  string
+				deleted  bool
+				parsedOK bool
+			}
+
+			rows, err := tx.Query("SELECT id, original_url, video_id, deleted_at IS NOT NULL FROM videos ORDER BY id")
+			if err != nil {
+				return fmt.Errorf("failed to query videos: %w", err)
+			}
+			var videos []video
+			for rows.Next() {
+				var v video
+				if err := rows.Scan(&v.id, &v.url, &v.videoID, &v.deleted); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan video: %w", err)
+				}
+				// Rows the stricter parser rejects keep the ID migration 3 gave them
+				if videoID, ok := migration11VideoIDFromURL(v.url); ok {
+					v.videoID, v.parsedOK = videoID, true
+				}
+				videos = append(videos, v)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate videos: %w", err)
+			}
+
+			// Keep one video per ID, preferring active ones and then the oldest
+			keepers := map[string]video{}
+			var duplicates []video
+			for _, v := range videos {
+				kept, seen := keepers[v.videoID]
+				switch {
+				case !seen:
+					keepers[v.videoID] = v
+				case kept.deleted && !v.deleted:
+					keepers[v.videoID] = v
+					duplicates = append(duplicates, kept)
+				default:
+					duplicates = append(duplicates, v)
+				}
+			}
+
+			// Move duplicates' subtitles to the video that's kept, unless it has the same language
+			// already, and soft-delete the duplicates. Their video_id is freed for the unique index,
+			// so restoring one brings back the subtitles left on it, like the merge endpoint
+			now := time.Now().UTC()
+			for _, dup := range duplicates {
+				keeper := keepers[dup.videoID]
+				_, err := tx.Exec(`UPDATE subtitles SET video_id = ? WHERE video_id = ? AND NOT EXISTS (
+						SELECT 1 FROM subtitles kept
+						WHERE kept.video_id = ? AND kept.language = subtitles.language AND kept.type = subtitles.type
+					)`, keeper.id, dup.id, keeper.id)
+				if err != nil {
+					return fmt.Errorf("failed to move subtitles of video %d: %w", dup.id, err)
+				}
+				_, err = tx.Exec("UPDATE videos SET video_id = ?, deleted_at = COALESCE(deleted_at, ?) WHERE id = ?",
+					"duplicate:"+dup.url, now, dup.id)
+				if err != nil {
+					return fmt.Errorf("failed to delete duplicate video %d: %w", dup.id, err)
+				}
+				slog.Warn("Merged duplicate video", "id", dup.id, "into", keeper.id, "video_id", dup.videoID)
+			}
+
+			for _, v := range keepers {
+				url := v.url
+				if v.parsedOK {
+					url = "https://www.youtube.com/watch?v=" + v.videoID
+				}
+				if _, err := tx.Exec("UPDATE videos SET video_id = ?, original_url = ? WHERE id = ?", v.videoID, url, v.id); err != nil {
+					return fmt.Errorf("failed to canonicalize video %d: %w", v.id, err)
+				}
+			}
+
+			if _, err := tx.Exec("DROP INDEX IF EXISTS idx_videos_video_id"); err != nil {
+				return fmt.Errorf("failed to drop videos.video_id index: %w", err)
+			}
+			if _, err := tx.Exec("CREATE UNIQUE INDEX idx_videos_video_id ON videos(video_id)"); err != nil {
+				return fmt.Errorf("failed to create videos.video_id unique index: %w", err)
+			}
+
+			return nil
+		},
+	},
+	{
+		description: "normalize subtitles.language",
+		up: func(tx *sql.Tx) error {
+			type subtitle struct {
+				id         int
+				videoID    int
+				language   string
+				subType    string
+				normalized string
+			}
+
+			rows, err := tx.Query("SELECT id, video_id, language, type FROM subtitles ORDER BY id")
+			if err != nil {
+				return fmt.Errorf("failed to query subtitles: %w", err)
+			}
+			var subtitles []subtitle
+			for rows.Next() {
+				var s subtitle
+				if err := rows.Scan(&s.id, &s.videoID, &s.language, &s.subType); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan subtitle: %w", err)
+				}
+				subtitles = append(subtitles, s)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("failed to iterate subtitles: %w", err)
+			}
+
+			// Of subtitles that normalize to the same language, the one already stored with it,
+			// or else the most recent, gets the language. The others keep theirs, so they're
+			// still listed and can be renamed or deleted by hand
+			type key struct {
+				videoID  int
+				language string
+				subType  string
+			}
+			keepers := map[key]subtitle{}
+			var duplicates []subtitle
+			for _, s := range subtitles {
+				normalized, err := migration12NormalizeLanguage(s.language)
+				if err != nil {
+					slog.Warn("Leaving subtitle with an invalid language as is", "id", s.id, "language", s.language)
+					normalized = s.language
+				}
+				s.normalized = normalized
+
+				k := key{s.videoID, normalized, s.subType}
+				kept, seen := keepers[k]
+				switch {
+				case !seen:
+					keepers[k] = s
+				case kept.language != kept.normalized:
+					keepers[k] = s
+					duplicates = append(duplicates, kept)
+				default:
+					duplicates = append(duplicates, s)
+				}
+			}
+
+			for _, dup := range duplicates {
+				slog.Warn("Leaving the language of a subtitle that would duplicate another once normalized",
+					"id", dup.id, "video_id", dup.videoID, "language", dup.language, "normalized", dup.normalized)
+			}
+
+			for _, s := range keepers {
+				if s.language == s.normalized {
+					continue
+				}
+				if _, err := tx.Exec("UPDATE subtitles SET language = ? WHERE id = ?", s.normalized, s.id); err != nil {
+					return fmt.Errorf("failed to normalize language of subtitle %d: %w", s.id, err)
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// runMigrations applies all pending migrations in order
+func (r *Repository) runMigrations() error {
+	sqlDB, ok := r.conn.Db.(*sql.DB)
+	if !ok {
+		return fmt.Errorf("failed to get sql.DB instance")
+	}
+
+	_, err := sqlDB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	err = sqlDB.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to get current schema version: %w", err)
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		m := migrations[i]
+
+		if err := applyMigration(sqlDB, version, m); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", version, m.description, err)
+		}
+
+		slog.Info("Applied migration", "version", version, "description", m.description)
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and records it in the same transaction
+func applyMigration(sqlDB *sql.DB, version int, m migration) error {
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, ?)", version, m.description)
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// migration3VideoIDFromURL is youtubeVideoIDFromURL as it was when migration 3 was written,
+// kept so the migration backfills the same IDs however the parser changes
+func migration3VideoIDFromURL(urlStr string) (string, bool) {
+	parsedURL, err := url.Parse(urlStr)
+	if err == nil {
+		if strings.Contains(parsedURL.Host, "youtube.com") || strings.Contains(parsedURL.Host, "youtube-nocookie.com") {
+			videoID := parsedURL.Query().Get("v")
+			if videoID != "" {
+				return videoID, true
+			}
+
+			segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+			if len(segments) >= 2 {
+				switch segments[0] {
+				case "shorts", "embed", "live":
+					if segments[1] != "" {
+						return segments[1], true
+					}
+				}
+			}
+		} else if strings.Contains(parsedURL.Host, "youtu.be") {
+			videoID := strings.TrimPrefix(parsedURL.Path, "/")
+			if videoID != "" {
+				return videoID, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// migration11VideoIDPattern and migration11VideoIDFromURL are youtubeVideoIDPattern and
+// youtubeVideoIDFromURL as they were when migration 11 was written
+var migration11VideoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+func migration11VideoIDFromURL(urlStr string) (string, bool) {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return "", false
+	}
+
+	var videoID string
+	switch strings.ToLower(parsedURL.Hostname()) {
+	case "youtube.com", "www.youtube.com", "m.youtube.com", "music.youtube.com",
+		"youtube-nocookie.com", "www.youtube-nocookie.com":
+		if parsedURL.Path == "/watch" {
+			videoID = parsedURL.Query().Get("v")
+			break
+		}
+
+		segments := strings.Split(strings.Trim(parsedURL.Path, "/"), "/")
+		if len(segments) == 2 {
+			switch segments[0] {
+			case "shorts", "embed", "live":
+				videoID = segments[1]
+			}
+		}
+	case "youtu.be", "www.youtu.be":
+		videoID = strings.Trim(parsedURL.Path, "/")
+	}
+
+	if !migration11VideoIDPattern.MatchString(videoID) {
+		return "", false
+	}
+	return videoID, true
+}
+
+// migration12NormalizeLanguage is normalizeLanguage as it was when migration 12 was written,
+// kept along with its language lists so the migration normalizes the same way however they change
+func migration12NormalizeLanguage(language string) (string, error) {
+	tag := strings.ToLower(strings.TrimSpace(language))
+	tag = strings.ReplaceAll(tag, "_", "-")
+
+	if code, ok := migration12LanguageNames[tag]; ok {
+		return code, nil
+	}
+
+	if !migration12LanguageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid language code %q", language)
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	if len(primary) == 2 && !migration12ISO6391Codes[primary] {
+		return "", fmt.Errorf("unknown language code %q", language)
+	}
+
+	return tag, nil
+}
+
+var migration12LanguageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+var migration12ISO6391Codes = toSet(strings.Fields(`
+	aa ab ae af ak am an ar as av ay az ba be bg bh bi bm bn bo br bs ca ce ch co cr cs cu cv cy
+	da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy ga gd gl gn gu gv ha he hi ho hr ht hu
+	hy hz ia id ie ig ii ik io is it iu ja jv ka kg ki kj kk kl km kn ko kr ks ku kv kw ky la lb
+	lg li ln lo lt lu lv mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny oc oj om
+	or os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se sg si sk sl sm sn so sq sr ss st su sv sw
+	ta te tg th ti tk tl tn to tr ts tt tw ty ug uk ur uz ve vi vo wa wo xh yi yo za zh zu
+`))
+
+var migration12LanguageNames = map[string]string{
+	"arabic":     "ar",
+	"bengali":    "bn",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"indonesian": "id",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"malay":      "ms",
+	"norwegian":  "no",
+	"persian":    "fa",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"thai":       "th",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+	"urdu":       "ur",
+	"vietnamese": "vi",
+}
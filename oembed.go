@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// youtubeOEmbedEndpoint describes a YouTube video, including its current title, without an API key
+const youtubeOEmbedEndpoint = "https://www.youtube.com/oembed"
+
+// maxOEmbedSize bounds how much of an oEmbed response is read, they are well under 1KB
+const maxOEmbedSize = 64 * 1024
+
+// errVideoUnavailable is returned when YouTube has no public video with the given ID
+var errVideoUnavailable = errors.New("video is unavailable on YouTube")
+
+var oembedHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchYouTubeTitle looks up a video's current title using YouTube's oEmbed endpoint
+func fetchYouTubeTitle(ctx context.Context, videoID string) (string, error) {
+	query := url.Values{
+		"url":    {youtubeCanonicalURL(videoID)},
+		"format": {"json"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, youtubeOEmbedEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := oembedHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach YouTube: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Private and removed videos are 401 and 404 respectively
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
+		return "", errVideoUnavailable
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch video details: unexpected status %s", resp.Status)
+	}
+
+	var oembed struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOEmbedSize)).Decode(&oembed); err != nil {
+		return "", fmt.Errorf("failed to read video details: %w", err)
+	}
+
+	title := strings.TrimSpace(oembed.Title)
+	if title == "" {
+		return "", errors.New("YouTube returned an empty title")
+	}
+	return title, nil
+}
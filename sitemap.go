@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sitemapMaxURLs is the most URLs the sitemap protocol allows in a single file
+const sitemapMaxURLs = 50000
+
+// sitemap lists the public page of every active video. Pages are the SPA's direct URL
+// route, e.g. https://subbed.example.com/https://www.youtube.com/watch?v=VIDEO_ID.
+// It's 404 without a baseURL, since the request's Host header is up to the client
+func sitemap(repo *Repository, baseURL string) fiber.Handler {
+	base := strings.TrimSuffix(baseURL, "/")
+	return func(c *fiber.Ctx) error {
+		if base == "" {
+			return fiber.NewError(fiber.StatusNotFound, "Sitemap is disabled, set BASE_URL to enable it")
+		}
+
+		c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+
+		// The sitemap is written after the handler returns, so it can't use the request context
+		reqID := requestID(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := writeSitemap(context.Background(), repo, base, w); err != nil {
+				slog.Error("Failed to write sitemap", "request_id", reqID, "error", err)
+			}
+		})
+		return nil
+	}
+}
+
+// writeSitemap writes the sitemap XML, loading videos in batches to bound memory use
+func writeSitemap(ctx context.Context, repo *Repository, base string, w *bufio.Writer) error {
+	w.WriteString(xml.Header)
+	w.WriteString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+
+	written := 0
+	for afterID := 0; written < sitemapMaxURLs; {
+		videos, err := repo.ListVideosAfterID(ctx, afterID, videoBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(videos) == 0 {
+			break
+		}
+		afterID = videos[len(videos)-1].ID
+
+		for _, video := range videos {
+			if video.VideoID == "" || written == sitemapMaxURLs {
+				continue
+			}
+
+			w.WriteString("  <url><loc>")
+			xml.EscapeText(w, []byte(base+"/"+youtubeCanonicalURL(video.VideoID)))
+			fmt.Fprintf(w, "</loc><lastmod>%s</lastmod></url>\n", video.UpdatedAt.UTC().Format(time.RFC3339))
+			written++
+		}
+
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to send sitemap: %w", err)
+		}
+	}
+
+	if written == sitemapMaxURLs {
+		slog.Warn("Sitemap truncated", "max_urls", sitemapMaxURLs)
+	}
+
+	w.WriteString("</urlset>\n")
+	return w.Flush()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSitemapIgnoresHostHeader(t *testing.T) {
+	repo := newTestRepository(t)
+	seedSubtitles(t, repo, 1)
+
+	tests := []struct {
+		name       string
+		baseURL    string
+		wantStatus int
+		wantLoc    string
+	}{
+		{"base url", "https://subbed.example.com/", fiber.StatusOK,
+			"<loc>https://subbed.example.com/https://www.youtube.com/watch?v=video000000</loc>"},
+		{"no base url", "", fiber.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.Get("/sitemap.xml", sitemap(repo, tt.baseURL))
+
+			req := httptest.NewRequest("GET", "/sitemap.xml", nil)
+			req.Host = "evil.example.com"
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("sitemap returned %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(body), "evil.example.com") {
+				t.Errorf("sitemap uses the Host header: %s", body)
+			}
+			if !strings.Contains(string(body), tt.wantLoc) {
+				t.Errorf("sitemap doesn't contain %s: %s", tt.wantLoc, body)
+			}
+		})
+	}
+}
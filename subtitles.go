@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// convertToSRT converts subtitle content of the given upload type to SRT.
+// Unknown types are assumed to already be SRT.
+func convertToSRT(content, fileType string) string {
+	content = normalizeNewlines(content)
+
+	switch fileType {
+	case "vtt":
+		return vttToSRT(content)
+	case "ass", "ssa":
+		return assToSRT(content)
+	case "sbv":
+		return sbvToSRT(content)
+	case "ttml", "dfxp":
+		return ttmlToSRT(content)
+	case "sub":
+		return microDVDToSRT(content, defaultMicroDVDFrameRate)
+	default:
+		return content
+	}
+}
+
+// newlineReplacer converts Windows (CRLF) and classic Mac (CR) line endings to LF
+var newlineReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// normalizeNewlines strips a leading UTF-8 byte order mark and converts line endings to LF
+func normalizeNewlines(content string) string {
+	return newlineReplacer.Replace(strings.TrimPrefix(content, "\uFEFF"))
+}
+
+// subtitleTypes are the upload formats convertToSRT understands
+var subtitleTypes = toSet([]string{"srt", "vtt", "ass", "ssa", "sbv", "ttml", "dfxp", "sub"})
+
+// resolveSubtitleType lowercases an upload format and reports whether it's supported.
+// An empty or "auto" format is detected from the content
+func resolveSubtitleType(fileType, content string) (string, bool) {
+	fileType = strings.ToLower(strings.TrimSpace(fileType))
+	if fileType == "" || fileType == "auto" {
+		return detectSubtitleFormat(content), true
+	}
+	return fileType, subtitleTypes[fileType]
+}
+
+// sniffSubtitleType guesses an upload's format from its content, or from the filename's
+// extension when the content has nothing distinctive. It returns "" if neither gives a hint
+func sniffSubtitleType(filename, content string) string {
+	if detected := detectSubtitleFormat(content); detected != "srt" {
+		return detected
+	}
+	fileType, _ := subtitleInfoFromFilename(filename)
+	return fileType
+}
+
+// subtitleTypeAliases maps formats to the one they share a converter with
+var subtitleTypeAliases = map[string]string{"ssa": "ass", "dfxp": "ttml"}
+
+// sameSubtitleFormat reports whether two upload types are converted the same way, e.g. "ass" and "ssa"
+func sameSubtitleFormat(a, b string) bool {
+	return cmp.Or(subtitleTypeAliases[a], a) == cmp.Or(subtitleTypeAliases[b], b)
+}
+
+// sbvTimestampLinePattern matches an SBV cue timing line, e.g. "0:00:01.000,0:00:02.500"
+var sbvTimestampLinePattern = regexp.MustCompile(`^\d+:\d{2}:\d{2}\.\d{3},\d+:\d{2}:\d{2}\.\d{3}$`)
+
+// detectSubtitleFormat guesses the upload type of subtitle content from its structure,
+// falling back to "srt" when nothing more specific matches
+func detectSubtitleFormat(content string) string {
+	content = strings.TrimSpace(normalizeNewlines(content))
+	firstLine, _, _ := strings.Cut(content, "\n")
+	firstLine = strings.TrimSpace(firstLine)
+	lower := strings.ToLower(content)
+
+	switch {
+	case strings.HasPrefix(firstLine, "WEBVTT"):
+		return "vtt"
+	case strings.Contains(lower, "[script info]") || strings.Contains(lower, "[events]"):
+		return "ass"
+	case strings.HasPrefix(content, "<") && ttmlRootPattern.MatchString(content):
+		return "ttml"
+	case sbvTimestampLinePattern.MatchString(firstLine):
+		return "sbv"
+	case microDVDLinePattern.MatchString(firstLine):
+		return "sub"
+	default:
+		return "srt"
+	}
+}
+
+// ttmlRootPattern matches a TTML root element, with or without a namespace prefix
+var ttmlRootPattern = regexp.MustCompile(`<(?:\w+:)?tt[\s>]`)
+
+// subtitleInfoFromFilename infers the upload type from a filename's extension, and the
+// language from an optional suffix before it, e.g. "movie.en.srt" gives "srt" and "en"
+func subtitleInfoFromFilename(filename string) (fileType, language string) {
+	parts := strings.Split(strings.ToLower(filepath.Base(filename)), ".")
+	if len(parts) < 2 {
+		return "", ""
+	}
+
+	if ext := parts[len(parts)-1]; subtitleTypes[ext] {
+		fileType = ext
+	}
+
+	if len(parts) >= 3 {
+		language = parts[len(parts)-2]
+	}
+
+	return fileType, language
+}
+
+// Cue is a single timed subtitle entry
+type Cue struct {
+	Index int
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// MarshalJSON encodes start and end times as integer milliseconds for web players
+func (c Cue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Index int    `json:"index"`
+		Start int64  `json:"start"`
+		End   int64  `json:"end"`
+		Text  string `json:"text"`
+	}{
+		Index: c.Index,
+		Start: c.Start.Milliseconds(),
+		End:   c.End.Milliseconds(),
+		Text:  c.Text,
+	})
+}
+
+// parseSRT parses SRT content into cues, reporting the line of the first malformed cue
+func parseSRT(srt string) ([]Cue, error) {
+	lines := strings.Split(normalizeNewlines(srt), "\n")
+
+	var cues []Cue
+	for i := 0; i < len(lines); {
+		// Skip blank lines between cues
+		if strings.TrimSpace(lines[i]) == "" {
+			i++
+			continue
+		}
+
+		lineNum := i + 1
+		blockStart := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+		block := lines[blockStart:i]
+		cueNum := len(cues) + 1
+
+		// The cue number line is optional in practice
+		if !strings.Contains(block[0], "-->") {
+			n, err := strconv.Atoi(strings.TrimSpace(block[0]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: expected cue number, got %q", lineNum, block[0])
+			}
+			if len(block) == 1 {
+				return nil, fmt.Errorf("line %d: cue %d is missing its timestamp line", lineNum, n)
+			}
+			cueNum = n
+			block = block[1:]
+			lineNum++
+		}
+
+		start, end, err := parseSRTTimeRange(block[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: cue %d has an invalid timestamp line %q: %w", lineNum, cueNum, block[0], err)
+		}
+		if end < start {
+			return nil, fmt.Errorf("line %d: cue %d ends before it starts", lineNum, cueNum)
+		}
+
+		cues = append(cues, Cue{
+			Index: cueNum,
+			Start: start,
+			End:   end,
+			Text:  strings.Join(block[1:], "\n"),
+		})
+	}
+
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no cues found")
+	}
+
+	return cues, nil
+}
+
+// cueSummary counts an SRT subtitle's cues and the time they span, from the earliest
+// start to the latest end. Content that doesn't parse has no cues
+func cueSummary(srt string) (count int, span time.Duration) {
+	cues, err := parseSRT(srt)
+	if err != nil {
+		return 0, 0
+	}
+
+	first, last := cues[0].Start, cues[0].End
+	for _, cue := range cues[1:] {
+		first = min(first, cue.Start)
+		last = max(last, cue.End)
+	}
+	return len(cues), last - first
+}
+
+// validateSRT checks that content is well-formed SRT with at least one cue
+func validateSRT(srt string) error {
+	_, err := parseSRT(srt)
+	return err
+}
+
+// parseSRTTimeRange parses a line like "00:00:01,000 --> 00:00:02,500"
+func parseSRTTimeRange(line string) (time.Duration, time.Duration, error) {
+	startStr, endStr, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, fmt.Errorf("missing \"-->\" separator")
+	}
+
+	start, err := parseTimestamp(strings.TrimSpace(startStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("start: %w", err)
+	}
+
+	// Ignore anything trailing the end timestamp, such as position settings
+	endFields := strings.Fields(endStr)
+	if len(endFields) == 0 {
+		return 0, 0, fmt.Errorf("missing end timestamp")
+	}
+	end, err := parseTimestamp(endFields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("end: %w", err)
+	}
+
+	return start, end, nil
+}
+
+// srtTimestampPattern matches an SRT timestamp (HH:MM:SS,mmm), hours may exceed two digits
+var srtTimestampPattern = regexp.MustCompile(`^(\d{2,}):(\d{2}):(\d{2}),(\d{3})$`)
+
+// parseTimestamp strictly parses an SRT timestamp (HH:MM:SS,mmm)
+func parseTimestamp(s string) (time.Duration, error) {
+	match := srtTimestampPattern.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("timestamp %q is not in HH:MM:SS,mmm format", s)
+	}
+
+	h, _ := strconv.Atoi(match[1])
+	m, _ := strconv.Atoi(match[2])
+	sec, _ := strconv.Atoi(match[3])
+	ms, _ := strconv.Atoi(match[4])
+	if m > 59 || sec > 59 {
+		return 0, fmt.Errorf("timestamp %q has minutes or seconds out of range", s)
+	}
+
+	return time.Duration(h)*time.Hour +
+		time.Duration(m)*time.Minute +
+		time.Duration(sec)*time.Second +
+		time.Duration(ms)*time.Millisecond, nil
+}
+
+// formatSRT serializes cues as SRT, numbering them by their Index
+func formatSRT(cues []Cue) string {
+	var b strings.Builder
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n",
+			cue.Index, formatSRTTimestamp(cue.Start), formatSRTTimestamp(cue.End), cue.Text)
+	}
+	return b.String()
+}
+
+// formatSRTTimestamp formats a duration as an SRT timestamp (HH:MM:SS,mmm)
+func formatSRTTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
+
+// How normalizeCues handles cues that overlap after sorting
+const (
+	overlapKeep  = "keep"  // leave overlaps as they are
+	overlapClip  = "clip"  // end each cue where the next one starts
+	overlapMerge = "merge" // combine overlapping cues into one
+)
+
+// cueWarnings reports problems that don't make cues invalid but are likely mistakes
+func cueWarnings(cues []Cue) []string {
+	warnings := []string{}
+	for i, cue := range cues {
+		if strings.TrimSpace(cue.Text) == "" {
+			warnings = append(warnings, fmt.Sprintf("cue %d has no text", cue.Index))
+		}
+		if cue.End == cue.Start {
+			warnings = append(warnings, fmt.Sprintf("cue %d has zero duration", cue.Index))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := cues[i-1]
+		switch {
+		case cue.Start < prev.Start:
+			warnings = append(warnings, fmt.Sprintf("cue %d starts before cue %d", cue.Index, prev.Index))
+		case cue.Start < prev.End:
+			warnings = append(warnings, fmt.Sprintf("cue %d overlaps cue %d", cue.Index, prev.Index))
+		}
+	}
+	return warnings
+}
+
+// CleanupSummary describes what cleanCues changed
+type CleanupSummary struct {
+	Changed          bool `json:"changed"`
+	CuesBefore       int  `json:"cues_before"`
+	CuesAfter        int  `json:"cues_after"`
+	TagsStripped     int  `json:"tags_stripped"`
+	EmptyCuesRemoved int  `json:"empty_cues_removed"`
+	Renumbered       bool `json:"renumbered"`
+}
+
+// cleanCues strips leftover VTT and ASS styling tags and entities from cue text, drops
+// cues left without text and renumbers the rest sequentially
+func cleanCues(cues []Cue) ([]Cue, CleanupSummary) {
+	summary := CleanupSummary{CuesBefore: len(cues)}
+
+	result := make([]Cue, 0, len(cues))
+	for _, cue := range cues {
+		summary.TagsStripped += len(vttTagPattern.FindAllStringIndex(cue.Text, -1)) +
+			len(assOverrideTagPattern.FindAllStringIndex(cue.Text, -1))
+
+		text := vttTagPattern.ReplaceAllString(cue.Text, "")
+		text = assOverrideTagPattern.ReplaceAllString(text, "")
+		text = vttEntityReplacer.Replace(text)
+
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			summary.EmptyCuesRemoved++
+			continue
+		}
+
+		cue.Text = strings.Join(lines, "\n")
+		result = append(result, cue)
+	}
+
+	for i := range result {
+		if result[i].Index != i+1 {
+			summary.Renumbered = true
+		}
+		result[i].Index = i + 1
+	}
+
+	summary.CuesAfter = len(result)
+	return result, summary
+}
+
+// splitBilingualCues splits cues with two languages stacked in their text: the first half
+// of each cue's lines goes to the first language and the rest to the second, so two-line
+// cues are split line by line. Cues left without text on a side are dropped from it, and
+// both sides are renumbered
+func splitBilingualCues(cues []Cue) (first, second []Cue) {
+	for _, cue := range cues {
+		lines := strings.Split(strings.TrimSpace(cue.Text), "\n")
+		half := (len(lines) + 1) / 2
+
+		if text := strings.TrimSpace(strings.Join(lines[:half], "\n")); text != "" {
+			first = append(first, Cue{Index: len(first) + 1, Start: cue.Start, End: cue.End, Text: text})
+		}
+		if text := strings.TrimSpace(strings.Join(lines[half:], "\n")); text != "" {
+			second = append(second, Cue{Index: len(second) + 1, Start: cue.Start, End: cue.End, Text: text})
+		}
+	}
+	return first, second
+}
+
+// shiftCues moves every cue by offset. Cues that would start before zero are an error
+// unless clamp is set, in which case their times are clamped to zero and cues ending at
+// or before zero are dropped
+func shiftCues(cues []Cue, offset time.Duration, clamp bool) ([]Cue, error) {
+	result := make([]Cue, 0, len(cues))
+	for _, cue := range cues {
+		cue.Start += offset
+		cue.End += offset
+
+		if cue.Start < 0 {
+			if !clamp {
+				return nil, fmt.Errorf("cue %d would start at a negative time", cue.Index)
+			}
+			if cue.End <= 0 {
+				continue
+			}
+			cue.Start = 0
+		}
+		result = append(result, cue)
+	}
+
+	for i := range result {
+		result[i].Index = i + 1
+	}
+
+	return result, nil
+}
+
+// normalizeCues sorts cues by start time, resolves overlaps according to
+// overlap and renumbers them sequentially
+func normalizeCues(cues []Cue, overlap string) []Cue {
+	sorted := slices.Clone(cues)
+	slices.SortStableFunc(sorted, func(a, b Cue) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	result := make([]Cue, 0, len(sorted))
+	for _, cue := range sorted {
+		if len(result) > 0 {
+			prev := &result[len(result)-1]
+			if cue.Start < prev.End {
+				switch overlap {
+				case overlapMerge:
+					prev.End = max(prev.End, cue.End)
+					prev.Text += "\n" + cue.Text
+					continue
+				case overlapClip:
+					// Cues starting together can't be clipped apart, so combine them
+					if cue.Start == prev.Start {
+						prev.End = max(prev.End, cue.End)
+						prev.Text += "\n" + cue.Text
+						continue
+					}
+					prev.End = cue.Start
+				}
+			}
+		}
+		result = append(result, cue)
+	}
+
+	for i := range result {
+		result[i].Index = i + 1
+	}
+
+	return result
+}
+
+// isOverlapMode reports whether mode is a valid normalizeCues overlap mode
+func isOverlapMode(mode string) bool {
+	return mode == overlapKeep || mode == overlapClip || mode == overlapMerge
+}
+
+// vttBlockSeparator matches one or more blank or whitespace-only lines between VTT blocks
+var vttBlockSeparator = regexp.MustCompile(`\n(?:[ \t]*\n)+`)
+
+// vttTagPattern matches VTT inline tags such as <c.colorCCCCCC>, <v Speaker>, </c> or <00:00:01.000>
+var vttTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// vttEntityReplacer decodes the HTML entities allowed in VTT cue text
+var vttEntityReplacer = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "", "&rlm;", "")
+
+func vttToSRT(vtt string) string {
+	vtt = normalizeNewlines(vtt)
+	// Runs of blank or whitespace-only lines all separate a single pair of blocks
+	blocks := vttBlockSeparator.Split(strings.TrimSpace(vtt), -1)
+
+	var srtLines []string
+	counter := 1
+
+	// A cue is written once the block after it shows it's complete, since YouTube's
+	// auto-captions put whitespace-only lines inside cues, splitting their text off
+	var timing string
+	var textLines []string
+	flush := func() {
+		// Drop cues left with no text, e.g. those containing only tags
+		if timing != "" && len(textLines) > 0 {
+			srtLines = append(srtLines, strconv.Itoa(counter), timing)
+			srtLines = append(srtLines, textLines...)
+			srtLines = append(srtLines, "")
+			counter++
+		}
+		timing, textLines = "", nil
+	}
+
+	for _, block := range blocks {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		first := strings.TrimSpace(lines[0])
+
+		// Skip the header and NOTE, STYLE and REGION blocks
+		if strings.HasPrefix(first, "WEBVTT") ||
+			first == "NOTE" || strings.HasPrefix(first, "NOTE ") ||
+			first == "STYLE" || first == "REGION" {
+			flush()
+			continue
+		}
+
+		// A block without a timing line, optionally after a cue identifier, continues the
+		// previous cue's text
+		timingLine := 0
+		if !strings.Contains(first, "-->") {
+			timingLine = 1
+		}
+		if timingLine >= len(lines) || !strings.Contains(lines[timingLine], "-->") {
+			if timing != "" {
+				textLines = append(textLines, vttCueText(lines)...)
+			}
+			continue
+		}
+
+		flush()
+
+		// Drop cue settings (e.g. "line:90% align:start") after the end timestamp
+		startStr, endStr, _ := strings.Cut(lines[timingLine], "-->")
+		endFields := strings.Fields(endStr)
+		if len(endFields) == 0 {
+			continue
+		}
+		timing = vttTimestampToSRT(startStr) + " --> " + vttTimestampToSRT(endFields[0])
+		textLines = vttCueText(lines[timingLine+1:])
+	}
+	flush()
+
+	return strings.Join(srtLines, "\n")
+}
+
+// vttCueText strips tags and entities from VTT cue text lines, dropping lines left blank
+func vttCueText(lines []string) []string {
+	var text []string
+	for _, line := range lines {
+		line = vttTagPattern.ReplaceAllString(line, "")
+		line = strings.TrimSpace(vttEntityReplacer.Replace(line))
+
+		// A blank line would terminate the SRT cue early
+		if line != "" {
+			text = append(text, line)
+		}
+	}
+	return text
+}
+
+// vttTimestampToSRT converts a VTT timestamp ([HH:]MM:SS.mmm) to SRT format (HH:MM:SS,mmm)
+func vttTimestampToSRT(ts string) string {
+	ts = strings.TrimSpace(ts)
+
+	// Hours are optional in VTT but required in SRT
+	if strings.Count(ts, ":") == 1 {
+		ts = "00:" + ts
+	}
+
+	return strings.Replace(ts, ".", ",", 1)
+}
+
+func srtToVTT(srt string) string {
+	var sb strings.Builder
+	// Reading from a string can't fail
+	_ = streamSRTToVTT(&sb, strings.NewReader(srt))
+	return sb.String()
+}
+
+// streamSRTToVTT converts SRT from r to WebVTT written to w, one line at a time so large
+// files don't have to fit in memory
+func streamSRTToVTT(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+
+	// Each line is held back until the next one is read, to tell cue counters from text
+	var pending string
+	hasPending := false
+	first := true
+	for {
+		chunk, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read subtitle: %w", err)
+		}
+		if chunk != "" {
+			if first {
+				chunk = strings.TrimPrefix(chunk, "\uFEFF")
+				first = false
+			}
+			chunk = strings.TrimSuffix(strings.TrimSuffix(chunk, "\n"), "\r")
+
+			// Old Mac files end lines with a lone \r
+			for _, line := range strings.Split(chunk, "\r") {
+				if hasPending {
+					writeVTTLine(bw, pending, line)
+				}
+				pending, hasPending = line, true
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if hasPending {
+		writeVTTLine(bw, pending, "")
+	}
+
+	return bw.Flush()
+}
+
+// writeVTTLine writes an SRT line as WebVTT, given the line after it
+func writeVTTLine(w *bufio.Writer, line, next string) {
+	line = strings.TrimSpace(line)
+
+	// Drop numeric cue counters that directly precede a timestamp
+	if _, err := strconv.Atoi(line); err == nil && strings.Contains(next, "-->") {
+		return
+	}
+
+	// Convert timestamp format (millisecond comma to dot)
+	if strings.Contains(line, "-->") {
+		line = strings.ReplaceAll(line, ",", ".")
+	}
+
+	w.WriteString(line)
+	w.WriteString("\n")
+}
+
+// sbvToSRT converts YouTube's SBV format to SRT
+func sbvToSRT(sbv string) string {
+	sbv = normalizeNewlines(sbv)
+	blocks := strings.Split(strings.TrimSpace(sbv), "\n\n")
+
+	var srtLines []string
+	counter := 1
+	for _, block := range blocks {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) < 2 {
+			continue
+		}
+
+		// Timestamp line: H:MM:SS.mmm,H:MM:SS.mmm
+		startStr, endStr, ok := strings.Cut(strings.TrimSpace(lines[0]), ",")
+		if !ok {
+			continue
+		}
+		start, ok := sbvTimestampToSRT(startStr)
+		if !ok {
+			continue
+		}
+		end, ok := sbvTimestampToSRT(endStr)
+		if !ok {
+			continue
+		}
+
+		srtLines = append(srtLines, strconv.Itoa(counter), start+" --> "+end)
+		srtLines = append(srtLines, lines[1:]...)
+		srtLines = append(srtLines, "")
+		counter++
+	}
+
+	return strings.Join(srtLines, "\n")
+}
+
+// sbvTimestampToSRT converts an SBV timestamp (H:MM:SS.mmm) to SRT format (HH:MM:SS,mmm)
+func sbvTimestampToSRT(ts string) (string, bool) {
+	var h, m, s, ms int
+	if _, err := fmt.Sscanf(strings.TrimSpace(ts), "%d:%d:%d.%d", &h, &m, &s, &ms); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms), true
+}
+
+// ttmlParagraph is a timed <p> element in a TTML document. Its inner XML is
+// kept raw so nested <span> and <br/> elements can be flattened to text.
+type ttmlParagraph struct {
+	Begin    string `xml:"begin,attr"`
+	End      string `xml:"end,attr"`
+	Dur      string `xml:"dur,attr"`
+	InnerXML string `xml:",innerxml"`
+}
+
+type ttmlDocument struct {
+	TickRate   string          `xml:"tickRate,attr"`
+	FrameRate  string          `xml:"frameRate,attr"`
+	Paragraphs []ttmlParagraph `xml:"body>div>p"`
+}
+
+// defaultMicroDVDFrameRate is assumed for MicroDVD files when no frame rate is given, the NTSC film rate
+const defaultMicroDVDFrameRate = 23.976
+
+// microDVDLinePattern matches a MicroDVD line, e.g. "{25}{75}Hello|world", capturing the frames and text
+var microDVDLinePattern = regexp.MustCompile(`^\{(\d+)\}\{(\d+)\}(.*)$`)
+
+// microDVDControlCodePattern matches MicroDVD formatting codes such as "{y:i}" or "{c:$0000FF}"
+var microDVDControlCodePattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// microDVDToSRT converts frame-based MicroDVD subtitles to SRT at the given frame rate.
+// A leading "{1}{1}25" line declares the file's own frame rate, which takes precedence
+func microDVDToSRT(sub string, fps float64) string {
+	var cues []Cue
+	first := true
+	for _, line := range strings.Split(sub, "\n") {
+		m := microDVDLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		startFrame, err1 := strconv.Atoi(m[1])
+		endFrame, err2 := strconv.Atoi(m[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		if first && startFrame == 1 && endFrame == 1 {
+			if declared, err := strconv.ParseFloat(strings.TrimSpace(m[3]), 64); err == nil && declared > 0 {
+				fps = declared
+				first = false
+				continue
+			}
+		}
+		first = false
+
+		// Control codes are dropped and "|" separates lines
+		text := microDVDControlCodePattern.ReplaceAllString(m[3], "")
+		text = strings.TrimSpace(strings.ReplaceAll(text, "|", "\n"))
+		if text == "" {
+			continue
+		}
+
+		cues = append(cues, Cue{
+			Index: len(cues) + 1,
+			Start: microDVDFrameTime(startFrame, fps),
+			End:   microDVDFrameTime(endFrame, fps),
+			Text:  text,
+		})
+	}
+
+	return formatSRT(cues)
+}
+
+// microDVDFrameTime converts a frame number to its timestamp, rounded to the millisecond
+func microDVDFrameTime(frame int, fps float64) time.Duration {
+	return time.Duration(float64(frame) / fps * float64(time.Second)).Round(time.Millisecond)
+}
+
+// ttmlToSRT converts a TTML/DFXP document to SRT
+func ttmlToSRT(ttml string) string {
+	var doc ttmlDocument
+	if err := xml.Unmarshal([]byte(normalizeNewlines(ttml)), &doc); err != nil {
+		return ""
+	}
+
+	tickRate, _ := strconv.ParseFloat(doc.TickRate, 64)
+	frameRate, _ := strconv.ParseFloat(doc.FrameRate, 64)
+	if frameRate == 0 {
+		frameRate = 30
+	}
+
+	var cues []Cue
+	for _, p := range doc.Paragraphs {
+		start, ok := parseTTMLTime(p.Begin, tickRate, frameRate)
+		if !ok {
+			continue
+		}
+
+		end, ok := parseTTMLTime(p.End, tickRate, frameRate)
+		if !ok {
+			dur, ok := parseTTMLTime(p.Dur, tickRate, frameRate)
+			if !ok {
+				continue
+			}
+			end = start + dur
+		}
+
+		text := ttmlText(p.InnerXML)
+		if text == "" {
+			continue
+		}
+
+		cues = append(cues, Cue{Index: len(cues) + 1, Start: start, End: end, Text: text})
+	}
+
+	return formatSRT(cues)
+}
+
+// ttmlBreakPattern matches <br/> elements, with or without a namespace prefix
+var ttmlBreakPattern = regexp.MustCompile(`<(?:\w+:)?br\s*/?>`)
+
+// ttmlText flattens the inner XML of a <p> element to plain text, dropping
+// span styling and turning <br/> into newlines
+func ttmlText(innerXML string) string {
+	innerXML = ttmlBreakPattern.ReplaceAllString(innerXML, "\n")
+
+	var b strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(innerXML))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			b.Write(data)
+		}
+	}
+
+	// Collapse the indentation whitespace XML documents are usually formatted with
+	lines := strings.Split(b.String(), "\n")
+	var textLines []string
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			textLines = append(textLines, line)
+		}
+	}
+	return strings.Join(textLines, "\n")
+}
+
+// ttmlOffsetPattern matches TTML offset times such as "1.5s", "200ms" or "90000t"
+var ttmlOffsetPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)(h|m|s|ms|f|t)$`)
+
+// parseTTMLTime parses a TTML time expression in clock-time (HH:MM:SS.mmm or
+// HH:MM:SS:FF) or offset-time (e.g. "1.5s", "90000t") form
+func parseTTMLTime(value string, tickRate, frameRate float64) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if m := ttmlOffsetPattern.FindStringSubmatch(value); m != nil {
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return 0, false
+		}
+		var seconds float64
+		switch m[2] {
+		case "h":
+			seconds = n * 3600
+		case "m":
+			seconds = n * 60
+		case "s":
+			seconds = n
+		case "ms":
+			seconds = n / 1000
+		case "f":
+			seconds = n / frameRate
+		case "t":
+			if tickRate == 0 {
+				return 0, false
+			}
+			seconds = n / tickRate
+		}
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	seconds := float64(h*3600+m*60) + sec
+
+	// HH:MM:SS:FF has a trailing frame count
+	if len(parts) == 4 {
+		frames, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds += frames / frameRate
+	}
+
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// subtitleFilename builds a download filename like "my-video.en.srt"
+func subtitleFilename(title, language, format string) string {
+	name := slugify(title)
+	if name == "" {
+		name = "subtitle"
+	}
+	if language != "" {
+		name += "." + language
+	}
+	return name + "." + format
+}
+
+// slugify lowercases s and joins its runs of letters and digits with dashes, e.g. "Hello, World!" -> "hello-world"
+func slugify(s string) string {
+	var b strings.Builder
+	lastDash := true
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastDash = false
+		} else if !lastDash {
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// assOverrideTagPattern matches ASS override blocks such as {\an8} or {\i1}
+var assOverrideTagPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// assToSRT converts the [Events] section of an ASS/SSA file to SRT
+func assToSRT(ass string) string {
+	lines := strings.Split(normalizeNewlines(ass), "\n")
+	var srtLines []string
+	counter := 1
+	inEvents := false
+
+	// Column positions, resolved from the Format: line
+	startCol, endCol, textCol := -1, -1, -1
+	numCols := 0
+
+	for _, rawLine := range lines {
+		line := strings.TrimSpace(rawLine)
+
+		// Track which section we are in
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Format":
+			columns := strings.Split(value, ",")
+			numCols = len(columns)
+			for i, column := range columns {
+				switch strings.TrimSpace(column) {
+				case "Start":
+					startCol = i
+				case "End":
+					endCol = i
+				case "Text":
+					textCol = i
+				}
+			}
+		case "Dialogue":
+			if startCol < 0 || endCol < 0 || textCol < 0 {
+				continue
+			}
+
+			// Text is the last column and may itself contain commas
+			fields := strings.SplitN(value, ",", numCols)
+			if len(fields) != numCols {
+				continue
+			}
+
+			start, ok := assTimestampToSRT(fields[startCol])
+			if !ok {
+				continue
+			}
+			end, ok := assTimestampToSRT(fields[endCol])
+			if !ok {
+				continue
+			}
+
+			text := assOverrideTagPattern.ReplaceAllString(fields[textCol], "")
+			text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+
+			srtLines = append(srtLines,
+				strconv.Itoa(counter),
+				start+" --> "+end,
+				text,
+				"",
+			)
+			counter++
+		}
+	}
+
+	return strings.Join(srtLines, "\n")
+}
+
+// assTimestampToSRT converts an ASS timestamp (H:MM:SS.cc) to SRT format (HH:MM:SS,mmm)
+func assTimestampToSRT(ts string) (string, bool) {
+	var h, m, s, cs int
+	if _, err := fmt.Sscanf(strings.TrimSpace(ts), "%d:%d:%d.%d", &h, &m, &s, &cs); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, cs*10), true
+}
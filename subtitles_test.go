@@ -0,0 +1,412 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSBVToSRT(t *testing.T) {
+	// As exported by YouTube Studio
+	sbv := `0:00:00.599,0:00:04.160
+>> ALICE: Hi, my name is Alice Miller and this is John Brown
+
+0:00:04.160,0:00:06.770
+>> JOHN: and we're the owners of Miller Bakery.
+
+0:00:06.770,0:00:10.880
+>> ALICE: Today we'll be teaching you how to make
+our famous apple cinnamon rolls.
+
+1:02:03.004,1:02:05.000
+An hour in
+`
+	want := `1
+00:00:00,599 --> 00:00:04,160
+>> ALICE: Hi, my name is Alice Miller and this is John Brown
+
+2
+00:00:04,160 --> 00:00:06,770
+>> JOHN: and we're the owners of Miller Bakery.
+
+3
+00:00:06,770 --> 00:00:10,880
+>> ALICE: Today we'll be teaching you how to make
+our famous apple cinnamon rolls.
+
+4
+01:02:03,004 --> 01:02:05,000
+An hour in
+`
+
+	got := sbvToSRT(sbv)
+	if got != want {
+		t.Errorf("sbvToSRT() =\n%s\nwant\n%s", got, want)
+	}
+	if err := validateSRT(got); err != nil {
+		t.Errorf("converted SBV isn't valid SRT: %v", err)
+	}
+	if format := detectSubtitleFormat(sbv); format != "sbv" {
+		t.Errorf("detectSubtitleFormat() = %q, want sbv", format)
+	}
+}
+
+func TestSBVToSRTSkipsMalformedBlocks(t *testing.T) {
+	sbv := "not a timestamp\nText\n\n0:00:01.000\nNo end time\n\n0:00:02.000,0:00:03.000\nKept\n"
+	want := "1\n00:00:02,000 --> 00:00:03,000\nKept\n"
+
+	if got := sbvToSRT(sbv); got != want {
+		t.Errorf("sbvToSRT() = %q, want %q", got, want)
+	}
+}
+
+func TestVTTToSRTYouTubeAutoCaptions(t *testing.T) {
+	// Trimmed from a YouTube auto-caption download. Each cue repeats the previous line, has
+	// word timings in <c> tags and a whitespace-only line separating its two lines
+	vtt := "WEBVTT\n" +
+		"Kind: captions\n" +
+		"Language: en\n" +
+		"\n" +
+		"STYLE\n" +
+		"::cue(c.colorE5E5E5) { color: rgb(229,229,229); }\n" +
+		"\n" +
+		"00:00:00.030 --> 00:00:02.389 align:start position:0%\n" +
+		" \n" +
+		"hello<00:00:00.539><c> everyone</c><00:00:00.960><c> welcome</c>\n" +
+		"\n" +
+		"00:00:02.389 --> 00:00:02.399 align:start position:0%\n" +
+		"hello everyone welcome\n" +
+		" \n" +
+		"\n" +
+		"NOTE this comment is\n" +
+		"dropped\n" +
+		"\n" +
+		"intro\n" +
+		"00:00:02.399 --> 00:00:05.120 line:90% align:start size:50%\n" +
+		"<v Roger Bingham><c.colorCCCCCC>to the</c> <i>show</i> &amp; more\n" +
+		"\n" +
+		"00:00:05.120 --> 00:00:06.000 align:start position:0%\n" +
+		"<c.colorE5E5E5> </c>\n"
+
+	want := "1\n" +
+		"00:00:00,030 --> 00:00:02,389\n" +
+		"hello everyone welcome\n" +
+		"\n" +
+		"2\n" +
+		"00:00:02,389 --> 00:00:02,399\n" +
+		"hello everyone welcome\n" +
+		"\n" +
+		"3\n" +
+		"00:00:02,399 --> 00:00:05,120\n" +
+		"to the show & more\n"
+
+	got := vttToSRT(vtt)
+	if got != want {
+		t.Errorf("vttToSRT() =\n%q\nwant\n%q", got, want)
+	}
+	if err := validateSRT(got); err != nil {
+		t.Errorf("converted VTT isn't valid SRT: %v", err)
+	}
+}
+
+func TestNormalizeCues(t *testing.T) {
+	// Out of order, with overlaps and two cues starting together
+	scrambled := `3
+00:00:05,000 --> 00:00:07,000
+Third
+
+1
+00:00:01,000 --> 00:00:03,500
+First
+
+2
+00:00:03,000 --> 00:00:05,500
+Second
+
+4
+00:00:05,000 --> 00:00:06,000
+Also third
+
+5
+00:00:09,000 --> 00:00:10,000
+Last
+`
+	cues, err := parseSRT(scrambled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		overlap string
+		want    string
+	}{
+		{overlapKeep, `1
+00:00:01,000 --> 00:00:03,500
+First
+
+2
+00:00:03,000 --> 00:00:05,500
+Second
+
+3
+00:00:05,000 --> 00:00:07,000
+Third
+
+4
+00:00:05,000 --> 00:00:06,000
+Also third
+
+5
+00:00:09,000 --> 00:00:10,000
+Last
+
+`},
+		{overlapClip, `1
+00:00:01,000 --> 00:00:03,000
+First
+
+2
+00:00:03,000 --> 00:00:05,000
+Second
+
+3
+00:00:05,000 --> 00:00:07,000
+Third
+Also third
+
+4
+00:00:09,000 --> 00:00:10,000
+Last
+
+`},
+		{overlapMerge, `1
+00:00:01,000 --> 00:00:07,000
+First
+Second
+Third
+Also third
+
+2
+00:00:09,000 --> 00:00:10,000
+Last
+
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.overlap, func(t *testing.T) {
+			normalized := normalizeCues(cues, tt.overlap)
+			if got := formatSRT(normalized); got != tt.want {
+				t.Errorf("normalizeCues(%s) =\n%s\nwant\n%s", tt.overlap, got, tt.want)
+			}
+
+			for i, cue := range normalized {
+				if cue.Index != i+1 {
+					t.Errorf("cue %d is numbered %d", i+1, cue.Index)
+				}
+				if i == 0 {
+					continue
+				}
+				prev := normalized[i-1]
+				if cue.Start < prev.Start {
+					t.Errorf("cue %d starts before cue %d", i+1, i)
+				}
+				if tt.overlap != overlapKeep && cue.Start < prev.End {
+					t.Errorf("cue %d overlaps cue %d", i+1, i)
+				}
+			}
+		})
+	}
+
+	// The input isn't modified
+	if cues[0].Text != "Third" || cues[0].Index != 3 {
+		t.Errorf("normalizeCues modified its input: %+v", cues[0])
+	}
+}
+
+func TestBlankLineRunsBetweenCues(t *testing.T) {
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nFirst\n\n\n\n" +
+		"2\n00:00:02,000 --> 00:00:03,000\nSecond\n \t\n" +
+		"3\n00:00:03,000 --> 00:00:04,000\nThird\n  \n\n  \n"
+
+	cues, err := parseSRT(srt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 3 {
+		t.Fatalf("parseSRT found %d cues, want 3", len(cues))
+	}
+	for i, text := range []string{"First", "Second", "Third"} {
+		if cues[i].Text != text {
+			t.Errorf("cue %d text = %q, want %q", i+1, cues[i].Text, text)
+		}
+	}
+
+	vtt := "WEBVTT\n\n\n" +
+		"00:01.000 --> 00:02.000\nFirst\n\n\n\n" +
+		"00:02.000 --> 00:03.000\n<c></c>\n\n" +
+		"00:03.000 --> 00:04.000\nThird\n \n\n"
+	want := "1\n00:00:01,000 --> 00:00:02,000\nFirst\n\n" +
+		"2\n00:00:03,000 --> 00:00:04,000\nThird\n"
+
+	if got := vttToSRT(vtt); got != want {
+		t.Errorf("vttToSRT() = %q, want %q", got, want)
+	}
+}
+
+func TestBOMAndWindowsLineEndings(t *testing.T) {
+	const bom = "\uFEFF"
+
+	vtt := bom + "WEBVTT\r\n\r\n00:00:01.000 --> 00:00:02.000 align:start\r\nHello\r\n\r\n00:00:02.500 --> 00:00:04.000\r\nTwo\r\nlines\r\n"
+	srt := bom + "1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n\r\n2\r\n00:00:02,500 --> 00:00:04,000\r\nTwo\r\nlines\r\n"
+	classicMac := strings.ReplaceAll(strings.TrimPrefix(srt, bom), "\r\n", "\r")
+	want := "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n2\n00:00:02,500 --> 00:00:04,000\nTwo\nlines\n"
+
+	if format := detectSubtitleFormat(vtt); format != "vtt" {
+		t.Errorf("detectSubtitleFormat(BOM + CRLF VTT) = %q, want vtt", format)
+	}
+	if format := detectSubtitleFormat(srt); format != "srt" {
+		t.Errorf("detectSubtitleFormat(BOM + CRLF SRT) = %q, want srt", format)
+	}
+
+	if got := convertToSRT(vtt, "vtt"); got != want {
+		t.Errorf("convertToSRT(BOM + CRLF VTT) = %q, want %q", got, want)
+	}
+	if got := convertToSRT(srt, "srt"); got != want {
+		t.Errorf("convertToSRT(BOM + CRLF SRT) = %q, want %q", got, want)
+	}
+	if got := convertToSRT(classicMac, "srt"); got != want {
+		t.Errorf("convertToSRT(CR SRT) = %q, want %q", got, want)
+	}
+
+	for name, input := range map[string]string{"BOM + CRLF": srt, "CR": classicMac} {
+		cues, err := parseSRT(input)
+		if err != nil {
+			t.Errorf("parseSRT(%s): %v", name, err)
+			continue
+		}
+		if len(cues) != 2 || cues[0].Index != 1 || cues[1].Text != "Two\nlines" {
+			t.Errorf("parseSRT(%s) = %+v", name, cues)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	valid := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"00:00:00,000", 0},
+		{"00:00:01,500", 1500 * time.Millisecond},
+		{"01:02:03,004", time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond},
+		{"123:59:59,999", 123*time.Hour + 59*time.Minute + 59*time.Second + 999*time.Millisecond},
+	}
+	for _, tt := range valid {
+		got, err := parseTimestamp(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	malformed := []string{
+		"",
+		"00:00:01.500",   // VTT separator
+		"00:00:01:500",   // colon separator
+		"00:00:01",       // missing milliseconds
+		"00:00:01,",      // empty milliseconds
+		"00:00:01,5",     // too few millisecond digits
+		"00:00:01,5000",  // too many millisecond digits
+		"0:00:01,500",    // single digit hours
+		"00:0:01,500",    // single digit minutes
+		"00:00:1,500",    // single digit seconds
+		"00:01,500",      // missing hours
+		"00:60:00,000",   // minutes out of range
+		"00:00:60,000",   // seconds out of range
+		"-00:00:01,000",  // negative
+		" 00:00:01,000",  // surrounding whitespace
+		"00:00:01,000 ",  // surrounding whitespace
+		"aa:bb:cc,ddd",   // not numbers
+		"00:00:01,000ms", // trailing garbage
+	}
+	for _, in := range malformed {
+		if got, err := parseTimestamp(in); err == nil {
+			t.Errorf("parseTimestamp(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseSRTReportsMalformedCue(t *testing.T) {
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nFine\n\n2\n00:00:03.000 --> 00:00:04,000\nBroken\n"
+
+	_, err := parseSRT(srt)
+	if err == nil {
+		t.Fatal("parseSRT accepted a malformed timestamp")
+	}
+	for _, want := range []string{"line 6", "cue 2", "00:00:03.000"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't mention %q", err, want)
+		}
+	}
+}
+
+func TestSplitBilingualCues(t *testing.T) {
+	bilingual := `1
+00:00:01,000 --> 00:00:02,000
+Hello
+Bonjour
+
+2
+00:00:03,000 --> 00:00:04,000
+Only one language
+
+3
+00:00:05,000 --> 00:00:06,000
+Two lines
+in English
+Deux lignes
+en français
+`
+	cues, err := parseSRT(bilingual)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := splitBilingualCues(cues)
+	tests := []struct {
+		name string
+		got  []Cue
+		want string
+	}{
+		{"first", first, `1
+00:00:01,000 --> 00:00:02,000
+Hello
+
+2
+00:00:03,000 --> 00:00:04,000
+Only one language
+
+3
+00:00:05,000 --> 00:00:06,000
+Two lines
+in English
+
+`},
+		// The single-line cue has nothing for the second language
+		{"second", second, `1
+00:00:01,000 --> 00:00:02,000
+Bonjour
+
+2
+00:00:05,000 --> 00:00:06,000
+Deux lignes
+en français
+
+`},
+	}
+
+	for _, tt := range tests {
+		if got := formatSRT(tt.got); got != tt.want {
+			t.Errorf("%s language:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
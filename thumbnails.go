@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// YouTube serves thumbnails at a URL derived from the video ID
+const (
+	youtubeThumbnailPrefix = "https://img.youtube.com/vi/"
+	youtubeThumbnailSuffix = "/hqdefault.jpg"
+)
+
+// maxThumbnailSize bounds how much of a proxied thumbnail is read, hqdefault images are ~30KB
+const maxThumbnailSize = 1024 * 1024
+
+// thumbnailCacheMaxAge is how long clients may cache a proxied thumbnail
+const thumbnailCacheMaxAge = 24 * time.Hour
+
+// Thumbnails are cached so a page of them, or a client requesting them in a loop, doesn't
+// turn into a fetch from YouTube per request
+const (
+	thumbnailCacheSize       = 1000 // ~30MB of hqdefault images
+	thumbnailCacheTTL        = 24 * time.Hour
+	thumbnailFailureCacheTTL = 5 * time.Minute
+)
+
+// errThumbnailNotFound is returned when YouTube has no thumbnail for a video
+var errThumbnailNotFound = errors.New("thumbnail not found")
+
+var thumbnailHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// youtubeThumbnailURL returns the URL of a video's high quality thumbnail
+func youtubeThumbnailURL(videoID string) string {
+	return youtubeThumbnailPrefix + videoID + youtubeThumbnailSuffix
+}
+
+// fetchThumbnail downloads a video's thumbnail from YouTube
+func fetchThumbnail(ctx context.Context, videoID string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, youtubeThumbnailURL(videoID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := thumbnailHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch thumbnail: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errThumbnailNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch thumbnail: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+	if len(body) > maxThumbnailSize {
+		return nil, fmt.Errorf("thumbnail exceeds the maximum size of %d bytes", maxThumbnailSize)
+	}
+
+	return body, nil
+}
+
+// thumbnailCacheEntry is a fetched thumbnail, or the error fetching it failed with
+type thumbnailCacheEntry struct {
+	image   []byte
+	err     error
+	expires time.Time
+}
+
+// thumbnailCache keeps recently fetched thumbnails by video ID, along with failed fetches
+// so they aren't retried on every request. It holds at most size entries
+type thumbnailCache struct {
+	mu         sync.Mutex
+	entries    map[string]thumbnailCacheEntry
+	size       int
+	ttl        time.Duration
+	failureTTL time.Duration
+}
+
+func newThumbnailCache(size int, ttl, failureTTL time.Duration) *thumbnailCache {
+	return &thumbnailCache{
+		entries:    make(map[string]thumbnailCacheEntry, size),
+		size:       size,
+		ttl:        ttl,
+		failureTTL: failureTTL,
+	}
+}
+
+// get returns a video's thumbnail, fetching it from YouTube unless it's cached
+func (tc *thumbnailCache) get(ctx context.Context, videoID string) ([]byte, error) {
+	now := time.Now()
+
+	tc.mu.Lock()
+	entry, ok := tc.entries[videoID]
+	tc.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.image, entry.err
+	}
+
+	image, err := fetchThumbnail(ctx, videoID)
+	// A cancelled request says nothing about the thumbnail
+	if ctx.Err() != nil {
+		return image, err
+	}
+
+	entry = thumbnailCacheEntry{image: image, err: err, expires: now.Add(tc.ttl)}
+	if err != nil {
+		entry.expires = now.Add(tc.failureTTL)
+	}
+	tc.put(videoID, entry, now)
+	return image, err
+}
+
+// put stores an entry, making room by dropping expired entries or else the one expiring soonest
+func (tc *thumbnailCache) put(videoID string, entry thumbnailCacheEntry, now time.Time) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if _, ok := tc.entries[videoID]; !ok && len(tc.entries) >= tc.size {
+		var soonest string
+		for id, e := range tc.entries {
+			if now.After(e.expires) {
+				delete(tc.entries, id)
+			} else if soonest == "" || e.expires.Before(tc.entries[soonest].expires) {
+				soonest = id
+			}
+		}
+		if len(tc.entries) >= tc.size {
+			delete(tc.entries, soonest)
+		}
+	}
+	tc.entries[videoID] = entry
+}
+
+// videoThumbnail proxies a video's YouTube thumbnail so pages don't hotlink img.youtube.com.
+// Only videos in the library are proxied, thumbnails are cached server side, and clients
+// are told to cache the image too
+func videoThumbnail(repo *Repository, cache *thumbnailCache) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		video, err := repo.GetVideoByURL(ctx, c.Params("videoID"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return fiber.NewError(fiber.StatusNotFound, "Video not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		image, err := cache.get(ctx, video.VideoID)
+		if errors.Is(err, errThumbnailNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Thumbnail not found")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadGateway, err.Error())
+		}
+
+		c.Set(fiber.HeaderContentType, "image/jpeg")
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(thumbnailCacheMaxAge.Seconds())))
+		return c.Send(image)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingTransport answers thumbnail requests without the network and counts them
+type countingTransport struct {
+	requests map[string]int
+}
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	videoID := strings.TrimSuffix(strings.TrimPrefix(req.URL.String(), youtubeThumbnailPrefix), youtubeThumbnailSuffix)
+	ct.requests[videoID]++
+
+	status := http.StatusOK
+	if strings.HasPrefix(videoID, "missing") {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(strings.NewReader("jpeg of " + videoID)),
+		Request:    req,
+	}, nil
+}
+
+func TestThumbnailCache(t *testing.T) {
+	transport := &countingTransport{requests: map[string]int{}}
+	client := thumbnailHTTPClient
+	thumbnailHTTPClient = &http.Client{Transport: transport}
+	t.Cleanup(func() { thumbnailHTTPClient = client })
+
+	cache := newThumbnailCache(2, time.Hour, time.Hour)
+	ctx := context.Background()
+
+	for range 3 {
+		image, err := cache.get(ctx, "video1")
+		if err != nil || string(image) != "jpeg of video1" {
+			t.Fatalf("get(video1) = %q, %v", image, err)
+		}
+		if _, err := cache.get(ctx, "missing1"); !errors.Is(err, errThumbnailNotFound) {
+			t.Fatalf("get(missing1) returned %v, want errThumbnailNotFound", err)
+		}
+	}
+	if transport.requests["video1"] != 1 || transport.requests["missing1"] != 1 {
+		t.Errorf("fetched %v, want each thumbnail fetched once", transport.requests)
+	}
+
+	// A third video evicts one of the others to stay within the size
+	if _, err := cache.get(ctx, "video2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("cache holds %d entries, want 2", len(cache.entries))
+	}
+
+	// Expired entries are fetched again
+	cache.ttl = -time.Second
+	cache.entries = map[string]thumbnailCacheEntry{}
+	for range 2 {
+		if _, err := cache.get(ctx, "video3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if transport.requests["video3"] != 2 {
+		t.Errorf("expired thumbnail fetched %d times, want 2", transport.requests["video3"])
+	}
+}